@@ -15,12 +15,19 @@ package main
 import (
 	"bufio"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/creack/pty"
 
@@ -43,22 +50,138 @@ func attachXterm(ptmx *os.File) {
 	xtermCmd.Run() // TODO: don't block here?
 }
 
-func serveStdout(ptmx *os.File) {
-	term := terminal.New(ptmx, terminal.WithUpgradeHook(attachXterm))
+var (
+	scrollbackLimit = flag.Int("scrollback", 0, "maximum number of scrollback lines to keep in memory (0 for unbounded)")
+	spillScrollback = flag.Bool("spill", false, "spill lines evicted from the scrollback to a temp file")
+	progressFrames  = flag.Int("frames", 0, "number of intermediate frames to keep for each progress line")
+	cssClasses      = flag.Bool("classes", false, "render styles as CSS classes instead of inline styles")
+	themePath       = flag.String("theme", "", "JSON theme file, see themes/ (defaults to xterm's colors)")
+	linkSchemes     = flag.String("link-schemes", "http,https,file", "comma-separated URI schemes allowed in hyperlinks")
+	minContrast     = flag.Float64("min-contrast", 0, "minimum WCAG contrast ratio for colored text against -theme's colors, e.g. 4.5")
+	darkThemePath   = flag.String("dark-theme", "", "JSON theme file to use when the browser prefers a dark color scheme (requires -classes; -min-contrast still uses -theme)")
+	pathLinks       = flag.Bool("path-links", false, "link paths to existing files in the output, relative to the shell's working directory")
+	clipboardRead   = flag.Bool("clipboard-read", false, "let the command read back what it copied to the clipboard (OSC 52)")
+	replayPath      = flag.String("replay", "", "serve an asciicast v2 recording instead of running a command")
+	inputIdle       = flag.Duration("input-idle", 0, "report that the command is waiting for input after this long without output, e.g. 500ms")
+)
+
+// loadThemes loads the selected themes. darkTheme is nil if none was selected.
+func loadThemes() (theme, darkTheme *terminal.Theme, err error) {
+	theme = &terminal.XtermTheme
+	if *themePath != "" {
+		if theme, err = terminal.LoadTheme(*themePath); err != nil {
+			return nil, nil, err
+		}
+	}
+	if *darkThemePath != "" {
+		if darkTheme, err = terminal.LoadTheme(*darkThemePath); err != nil {
+			return nil, nil, err
+		}
+	}
+	return theme, darkTheme, nil
+}
+
+// writeThemes writes the stylesheet for the selected themes.
+func writeThemes(w io.Writer, theme, darkTheme *terminal.Theme) error {
+	if err := theme.WriteStylesheet(w); err != nil {
+		return err
+	}
+	if darkTheme == nil {
+		return nil
+	}
+	fmt.Fprintln(w, "@media (prefers-color-scheme: dark) {")
+	if err := darkTheme.WriteStylesheet(w); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// serveStdout serves the output of the terminal that newTerminal creates with the given options.
+func serveStdout(newTerminal func(...terminal.RichTextTerminalOption) *terminal.RichTextTerminal) {
+	theme, darkTheme, err := loadThemes()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	opts := []terminal.RichTextTerminalOption{
+		terminal.WithTheme(theme),
+		terminal.WithScrollbackLimit(*scrollbackLimit),
+		terminal.WithProgressFrames(*progressFrames),
+		terminal.WithMinimumContrast(*minContrast),
+		terminal.WithClipboardPolicy(terminal.ClipboardPolicy{AllowRead: *clipboardRead}),
+	}
+	opts = append(opts, terminal.WithLinkPolicy(terminal.LinkPolicy{Schemes: strings.Split(*linkSchemes, ",")}))
+	if *cssClasses {
+		opts = append(opts, terminal.WithCSSClasses())
+	}
+	if *pathLinks {
+		opts = append(opts, terminal.WithPathLinks())
+	}
+	if *inputIdle > 0 {
+		opts = append(opts, terminal.WithInputDetection(*inputIdle))
+	}
+	if *spillScrollback {
+		opts = append(opts, terminal.WithScrollbackSpill(""))
+	}
+	term := newTerminal(opts...)
+	defer term.Close()
 	server := http.Server{Addr: "localhost:3000"}
 
+	// The first request from a browser starts the command, and the first one after it exits stops the server.
+	var seenMu sync.Mutex
 	var seen bool
 	waitForBrowser := make(chan struct{})
-	http.HandleFunc("/stdout", func(w http.ResponseWriter, req *http.Request) {
+	browserSeen := func() {
+		seenMu.Lock()
+		defer seenMu.Unlock()
 		if !seen {
 			waitForBrowser <- struct{}{}
 			seen = true
 		}
+	}
+	http.HandleFunc("/stdout", func(w http.ResponseWriter, req *http.Request) {
+		browserSeen()
 		for _, l := range term.Lines() {
 			w.Write([]byte(l))
 			w.Write([]byte{'\n'})
 		}
 	})
+	// /changes?rev=N returns the lines appended or modified since revision N, as JSON.
+	http.HandleFunc("/changes", func(w http.ResponseWriter, req *http.Request) {
+		browserSeen()
+		rev, _ := strconv.ParseUint(req.URL.Query().Get("rev"), 10, 64)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(term.ChangesSince(rev))
+	})
+	http.HandleFunc("/theme.css", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		if err := writeThemes(w, theme, darkTheme); err != nil {
+			log.Printf("could not write theme: %v", err)
+		}
+	})
+	http.HandleFunc("/commands", func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(term.Commands())
+	})
+	// /history?start=N&end=M pages through every line written so far, including lines evicted from memory.
+	http.HandleFunc("/history", func(w http.ResponseWriter, req *http.Request) {
+		snap := term.Snapshot()
+		start, _ := strconv.Atoi(req.URL.Query().Get("start"))
+		end, err := strconv.Atoi(req.URL.Query().Get("end"))
+		if err != nil {
+			end = snap.HistoryLen()
+		}
+		lines, err := snap.History(start, end)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		}
+		for _, l := range lines {
+			w.Write([]byte(l))
+			w.Write([]byte{'\n'})
+		}
+	})
 	http.Handle("/", http.FileServer(http.Dir("web")))
 	fmt.Println("Serving stdout on http://localhost:3000")
 	go server.ListenAndServe()
@@ -66,11 +189,28 @@ func serveStdout(ptmx *os.File) {
 	<-waitForBrowser
 	term.Run(context.Background())
 
+	seenMu.Lock()
 	seen = false
+	seenMu.Unlock()
 	<-waitForBrowser
 	server.Shutdown(context.Background())
 }
 
+func replay(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	src, err := terminal.NewAsciicastSource(f)
+	if err != nil {
+		log.Fatal(err)
+	}
+	serveStdout(func(opts ...terminal.RichTextTerminalOption) *terminal.RichTextTerminal {
+		return terminal.NewReplay(src, opts...)
+	})
+}
+
 func printStdErr(pipe *os.File) {
 	scanner := bufio.NewScanner(pipe)
 	for scanner.Scan() {
@@ -82,8 +222,17 @@ func main() {
 	flag.Parse()
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
-	if len(os.Args) < 2 {
-		log.Fatal("usage: terminal_parser <command> <args>...")
+	// Inline styles have -theme's palette baked in, so a dark stylesheet would only swap the default colors.
+	if *darkThemePath != "" && !*cssClasses {
+		log.Fatal("-dark-theme requires -classes")
+	}
+
+	if *replayPath != "" {
+		replay(*replayPath)
+		return
+	}
+	if flag.NArg() < 1 {
+		log.Fatal("usage: terminal_parser [flags] <command> <args>...")
 	}
 
 	ptmx, pts, err := pty.Open()
@@ -97,9 +246,21 @@ func main() {
 		log.Println(err)
 	}
 
+	// The shell integration script includes this nonce in the command lines it reports. It's passed in VSCODE_NONCE,
+	// which every process started from the shell would inherit, so the script must read and unset it, as VS Code's
+	// scripts do; otherwise programs running in the shell could use it to spoof command lines.
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		log.Fatal(err)
+	}
+	nonceHex := hex.EncodeToString(nonce)
+
 	waitForOutput := make(chan struct{})
 	go func() {
-		serveStdout(ptmx)
+		serveStdout(func(opts ...terminal.RichTextTerminalOption) *terminal.RichTextTerminal {
+			return terminal.New(ptmx, append(opts,
+				terminal.WithUpgradeHook(attachXterm), terminal.WithShellIntegrationNonce(nonceHex))...)
+		})
 		waitForOutput <- struct{}{}
 	}()
 	go func() {
@@ -107,11 +268,12 @@ func main() {
 		waitForOutput <- struct{}{}
 	}()
 
-	cmd := exec.Command(os.Args[1], os.Args[2:]...)
+	cmd := exec.Command(flag.Arg(0), flag.Args()[1:]...)
 	cmd.Stdin = pts
 	cmd.Stdout = pts
 	cmd.Stderr = pipe
 	//cmd.Stderr = pts
+	cmd.Env = append(os.Environ(), "VSCODE_NONCE="+nonceHex)
 
 	err = cmd.Start()
 	if err != nil {
@@ -0,0 +1,128 @@
+package terminal
+
+import (
+	"sort"
+	"time"
+)
+
+// Every line has a stable ID: its index among all lines ever written, so the first line is 0 and IDs are never
+// reused, even after eviction. Together with the screen's revision counter, this lets clients sync incrementally
+// instead of re-rendering the whole history on every poll.
+
+// Changes describes what changed on the screen after some revision.
+type Changes struct {
+	// Rev is the revision these changes bring the client up to. Pass it to the next ChangesSince call.
+	Rev uint64 `json:"rev"`
+	// Lines are in ID order. A line with a new ID should be appended; a line with an ID the client has already seen
+	// (the previously active line) replaces it. If lines were evicted before the client caught up, the first ID
+	// skips ahead; those lines can still be read with History.
+	Lines []LineChange `json:"lines"`
+	// First is the ID of the oldest line still in memory. Clients that mirror the scrollback can drop older lines.
+	First int `json:"first"`
+	// Colors is set if the application changed the palette or default colors (OSC 4, 10, 11, ...).
+	Colors *ColorChanges `json:"colors,omitempty"`
+	// Title is the current window title and icon name. It's set on the first call (rev 0) and whenever they change.
+	Title *TitleChanged `json:"title,omitempty"`
+	// Events are the events emitted after rev, oldest first. Only recent events are kept, so a client that falls far
+	// behind may miss some.
+	Events []EventChange `json:"events,omitempty"`
+}
+
+// EventChange is an Event tagged with its type, so clients can tell events apart in JSON.
+type EventChange struct {
+	Type  string `json:"type"`
+	Event Event  `json:"event"`
+}
+
+// ColorChanges is the full set of colors that differ from the theme, as CSS colors. Clients should apply them to
+// lines they already have too, which is only possible in class mode (see WithCSSClasses).
+type ColorChanges struct {
+	Foreground string         `json:"foreground,omitempty"`
+	Background string         `json:"background,omitempty"`
+	Cursor     string         `json:"cursor,omitempty"`
+	Palette    map[int]string `json:"palette,omitempty"`
+}
+
+func newColorChanges(p, base *palette) *ColorChanges {
+	changed := func(c, baseColor Color) string {
+		if c == nil || c == baseColor {
+			return ""
+		}
+		return c.HTMLColorCode()
+	}
+
+	ret := &ColorChanges{
+		Foreground: changed(p.fg, base.fg),
+		Background: changed(p.bg, base.bg),
+		Cursor:     changed(p.cursor, base.cursor),
+	}
+	for i := range p.colors {
+		if css := changed(p.colors[i], base.colors[i]); css != "" {
+			if ret.Palette == nil {
+				ret.Palette = map[int]string{}
+			}
+			ret.Palette[i] = css
+		}
+	}
+	return ret
+}
+
+type LineChange struct {
+	ID   int    `json:"id"`
+	HTML string `json:"html"`
+
+	// When the line was first written and last modified. Zero if nothing has been written to it yet.
+	Created  time.Time `json:"created"`
+	Modified time.Time `json:"modified"`
+
+	// Progress is set if the line was redrawn in place several times, like a progress bar or spinner.
+	Progress bool `json:"progress"`
+}
+
+func newLineChange(r htmlRenderer, id int, l line) LineChange {
+	return LineChange{
+		ID:       id,
+		HTML:     r.renderLine(l),
+		Created:  unixNanoTime(l.created),
+		Modified: unixNanoTime(l.modified),
+		Progress: l.progress(),
+	}
+}
+
+func unixNanoTime(ns int64) time.Time {
+	if ns == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ns)
+}
+
+// ChangesSince returns the lines that were appended or modified after revision rev. Passing 0 returns every line
+// still in memory.
+func (sn Snapshot) ChangesSince(rev uint64) Changes {
+	changes := Changes{Rev: sn.rev, First: sn.evicted}
+
+	first := sort.Search(len(sn.scrollback), func(i int) bool {
+		return sn.scrollback[i].rev > rev
+	})
+	for i := first; i < len(sn.scrollback); i++ {
+		changes.Lines = append(changes.Lines, newLineChange(sn.renderer, sn.evicted+i, sn.scrollback[i]))
+	}
+	if sn.activeRev > rev {
+		changes.Lines = append(changes.Lines, newLineChange(sn.renderer, sn.HistoryLen()-1, sn.active))
+	}
+	if sn.paletteRev > rev {
+		changes.Colors = newColorChanges(sn.renderer.palette, sn.basePalette)
+	}
+	if rev == 0 || sn.titleRev > rev {
+		changes.Title = &TitleChanged{sn.title.title, sn.title.iconName}
+	}
+	for _, ev := range sn.eventsSince(rev) {
+		changes.Events = append(changes.Events, EventChange{ev.EventType(), ev.Event})
+	}
+	return changes
+}
+
+// ChangesSince is shorthand for Snapshot().ChangesSince(rev).
+func (s *screen) ChangesSince(rev uint64) Changes {
+	return s.Snapshot().ChangesSince(rev)
+}
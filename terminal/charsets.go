@@ -0,0 +1,41 @@
+package terminal
+
+// charsets tracks the G0-G3 character set designations (SCS) and which of them is invoked into GL.
+// See: https://vt100.net/docs/vt510-rm/SCS.html
+//
+// The only non-ASCII set we translate is DEC Special Graphics, which is still widely used to draw boxes and lines.
+type charsets struct {
+	g  [4]byte // final byte of the designating escape sequence; 0 means the default (ASCII)
+	gl int
+}
+
+func (c *charsets) designate(slot int, final byte) {
+	if final == 'B' {
+		final = 0
+	}
+	c.g[slot] = final
+}
+
+func (c *charsets) shift(slot int) {
+	c.gl = slot
+}
+
+func (c *charsets) translate(r rune) rune {
+	switch c.g[c.gl] {
+	case '0': // DEC Special Graphics
+		if r >= 0x5f && r <= 0x7e {
+			return decSpecialGraphics[r-0x5f]
+		}
+	case 'A': // United Kingdom
+		if r == '#' {
+			return '£'
+		}
+	}
+	return r
+}
+
+var decSpecialGraphics = [...]rune{
+	' ', // _ (blank)
+	'◆', '▒', '␉', '␌', '␍', '␊', '°', '±', '␤', '␋', '┘', '┐', '┌', '└', '┼', '⎺',
+	'⎻', '─', '⎼', '⎽', '├', '┤', '┴', '┬', '│', '≤', '≥', 'π', '≠', '£', '·',
+}
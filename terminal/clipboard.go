@@ -0,0 +1,76 @@
+package terminal
+
+import (
+	"encoding/base64"
+	"log"
+	"strings"
+)
+
+// Applications copy to the clipboard with OSC 52;<selections>;<base64 text>, and read it back with
+// OSC 52;<selections>;?. The terminal can't reach the viewer's clipboard, so copies are passed on as events, and reads
+// are answered with what was last copied through the terminal, if the ClipboardPolicy allows them.
+
+// ClipboardPolicy decides what applications can do with the clipboard.
+type ClipboardPolicy struct {
+	// AllowRead answers requests to read the clipboard, which are ignored otherwise. Reads only return text that
+	// applications copied through this terminal, but that may still be sensitive, e.g. a password copied by a password
+	// manager.
+	AllowRead bool
+}
+
+// ClipboardCopied is emitted when the application copies text, or clears the selection (Text is "").
+type ClipboardCopied struct {
+	// Selections are xterm's selection names: c for the clipboard, p for the primary selection, s for the
+	// configurable selection, and 0-7 for cut buffers.
+	Selections string `json:"selections"`
+	Text       string `json:"text"`
+}
+
+func (ClipboardCopied) EventType() string { return "clipboardCopied" }
+
+// handleClipboard handles the params of OSC 52 after the "52".
+func (t *RichTextTerminal) handleClipboard(params []string) {
+	if len(params) < 2 {
+		return
+	}
+	selections, data := params[0], params[1]
+	if selections == "" {
+		selections = "s0"
+	}
+
+	if data == "?" {
+		if !t.screen.clipboardPolicy.AllowRead {
+			log.Print("denied a request to read the clipboard")
+			return
+		}
+		for _, sel := range []byte(selections) {
+			if text, ok := t.screen.clipboard[sel]; ok {
+				t.queueReply("\x1b]52;%c;%s\x1b\\", sel, base64.StdEncoding.EncodeToString([]byte(text)))
+				return
+			}
+		}
+		t.queueReply("\x1b]52;%c;\x1b\\", selections[0])
+		return
+	}
+
+	// Like xterm, anything that isn't valid base64 clears the selections.
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+	if err != nil {
+		decoded = nil
+	}
+	t.screen.setClipboard(selections, string(decoded))
+}
+
+func (s *screen) setClipboard(selections, text string) {
+	if s.clipboard == nil {
+		s.clipboard = map[byte]string{}
+	}
+	for _, sel := range []byte(selections) {
+		if text == "" {
+			delete(s.clipboard, sel)
+		} else {
+			s.clipboard[sel] = text
+		}
+	}
+	s.emit(ClipboardCopied{selections, text})
+}
@@ -0,0 +1,26 @@
+package terminal
+
+import "testing"
+
+func TestClipboardRead(t *testing.T) {
+	const input = "\x1b]52;c;aGVsbG8=\x07\x1b]52;c;?\x07\x1b]52;p;?\x07"
+	tests := []struct {
+		name   string
+		policy ClipboardPolicy
+		want   string
+	}{
+		{"denied by default", ClipboardPolicy{}, ""},
+		{"allowed", ClipboardPolicy{AllowRead: true}, "\x1b]52;c;aGVsbG8=\x1b\\\x1b]52;p;\x1b\\"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			replies, term := runWithReplies(t, input, WithClipboardPolicy(test.policy))
+			if replies != test.want {
+				t.Errorf("replies = %q, want %q", replies, test.want)
+			}
+			if got := term.screen.clipboard['c']; got != "hello" {
+				t.Errorf("clipboard = %q, want hello", got)
+			}
+		})
+	}
+}
@@ -1,6 +1,9 @@
 package terminal
 
-import "fmt"
+import (
+	"fmt"
+	"math"
+)
 
 type Color interface {
 	HTMLColorCode() string
@@ -13,14 +16,153 @@ func (c ANSIColor) HTMLColorCode() string {
 	return ansiColorPalette[c]
 }
 
+// RGB returns the color's components in the default palette. Use NewRGBColor(c.RGB()) to convert it to an RGBColor.
+func (c ANSIColor) RGB() (r, g, b uint8) {
+	return defaultRGB[c].RGB()
+}
+
+// ToANSI16 returns the nearest of the 16 basic colors, for outputs that don't support the 256-color palette.
+func (c ANSIColor) ToANSI16() ANSIColor {
+	if c < 16 {
+		return c
+	}
+	return defaultRGB[c].ToANSI16()
+}
+
 type RGBColor struct {
 	r, g, b uint8
 }
 
+func NewRGBColor(r, g, b uint8) RGBColor {
+	return RGBColor{r, g, b}
+}
+
 func (c RGBColor) HTMLColorCode() string {
 	return fmt.Sprintf("#%02x%02x%02x", c.r, c.g, c.b)
 }
 
+// RGB returns the color's components.
+func (c RGBColor) RGB() (r, g, b uint8) {
+	return c.r, c.g, c.b
+}
+
+// ToANSI256 returns the nearest color in the 6x6x6 color cube or the grayscale ramp of the 256-color palette.
+// The first 16 colors are skipped, since they're usually redefined by themes.
+func (c RGBColor) ToANSI256() ANSIColor {
+	cubeIndex := func(v uint8) int {
+		switch {
+		case v < 48:
+			return 0
+		case v < 115:
+			return 1
+		default:
+			return (int(v) - 35) / 40
+		}
+	}
+	ri, gi, bi := cubeIndex(c.r), cubeIndex(c.g), cubeIndex(c.b)
+	cube := ANSIColor(16 + 36*ri + 6*gi + bi)
+
+	average := (int(c.r) + int(c.g) + int(c.b)) / 3
+	grayIndex := 23
+	if average < 238 {
+		grayIndex = clamp((average-3)/10, 0, 23)
+	}
+	gray := ANSIColor(232 + grayIndex)
+
+	if colorDistance(c, defaultRGB[gray]) < colorDistance(c, defaultRGB[cube]) {
+		return gray
+	}
+	return cube
+}
+
+// ToANSI16 returns the nearest of the 16 basic colors in the default palette.
+func (c RGBColor) ToANSI16() ANSIColor {
+	nearest, nearestDistance := Black, math.Inf(1)
+	for i := Black; i <= BrightWhite; i++ {
+		if d := colorDistance(c, defaultRGB[i]); d < nearestDistance {
+			nearest, nearestDistance = i, d
+		}
+	}
+	return nearest
+}
+
+// colorDistance approximates how different two colors look, using the "redmean" weighted Euclidean distance.
+// See https://www.compuphase.com/cmetric.htm
+func colorDistance(c1, c2 RGBColor) float64 {
+	rMean := (float64(c1.r) + float64(c2.r)) / 2
+	dr, dg, db := float64(c1.r)-float64(c2.r), float64(c1.g)-float64(c2.g), float64(c1.b)-float64(c2.b)
+	return math.Sqrt((2+rMean/256)*dr*dr + 4*dg*dg + (2+(255-rMean)/256)*db*db)
+}
+
+// Luminance returns the relative luminance of c, as defined by WCAG 2.
+// See https://www.w3.org/TR/WCAG21/#dfn-relative-luminance
+func (c RGBColor) Luminance() float64 {
+	linear := func(v uint8) float64 {
+		s := float64(v) / 255
+		if s <= 0.04045 {
+			return s / 12.92
+		}
+		return math.Pow((s+0.055)/1.055, 2.4)
+	}
+	return 0.2126*linear(c.r) + 0.7152*linear(c.g) + 0.0722*linear(c.b)
+}
+
+// WithContrast returns c, lightened or darkened as little as possible to reach at least the given contrast ratio
+// against bg. If that isn't possible, it returns whichever of black or white has more contrast.
+func (c RGBColor) WithContrast(bg RGBColor, ratio float64) RGBColor {
+	if ContrastRatio(c, bg) >= ratio {
+		return c
+	}
+
+	mix := func(target RGBColor, t float64) RGBColor {
+		lerp := func(a, b uint8) uint8 {
+			return uint8(math.Round(float64(a) + (float64(b)-float64(a))*t))
+		}
+		return RGBColor{lerp(c.r, target.r), lerp(c.g, target.g), lerp(c.b, target.b)}
+	}
+	// Contrast increases monotonically as we move towards black or white, so binary search for the smallest change.
+	towards := func(target RGBColor) (RGBColor, bool) {
+		if ContrastRatio(target, bg) < ratio {
+			return target, false
+		}
+		lo, hi := 0.0, 1.0
+		for i := 0; i < 16; i++ {
+			if mid := (lo + hi) / 2; ContrastRatio(mix(target, mid), bg) >= ratio {
+				hi = mid
+			} else {
+				lo = mid
+			}
+		}
+		return mix(target, hi), true
+	}
+
+	black, white := RGBColor{}, RGBColor{0xff, 0xff, 0xff}
+	first, second := black, white
+	if c.Luminance() >= bg.Luminance() {
+		first, second = white, black
+	}
+	if adjusted, ok := towards(first); ok {
+		return adjusted
+	}
+	if adjusted, ok := towards(second); ok {
+		return adjusted
+	}
+	if ContrastRatio(black, bg) > ContrastRatio(white, bg) {
+		return black
+	}
+	return white
+}
+
+// ContrastRatio returns the WCAG 2 contrast ratio between two colors, from 1 (none) to 21 (black on white).
+// See https://www.w3.org/TR/WCAG21/#dfn-contrast-ratio
+func ContrastRatio(c1, c2 RGBColor) float64 {
+	l1, l2 := c1.Luminance(), c2.Luminance()
+	if l1 < l2 {
+		l1, l2 = l2, l1
+	}
+	return (l1 + 0.05) / (l2 + 0.05)
+}
+
 const (
 	Black ANSIColor = iota
 	Red
@@ -41,6 +183,21 @@ const (
 	BrightWhite
 )
 
+// defaultRGB is ansiColorPalette as RGB values.
+var defaultRGB = func() (ret [256]RGBColor) {
+	// The CSS color names used for the first 16 colors
+	copy(ret[:], []RGBColor{
+		{0x00, 0x00, 0x00}, {0x80, 0x00, 0x00}, {0x00, 0x80, 0x00}, {0x80, 0x80, 0x00},
+		{0x00, 0x00, 0x80}, {0x80, 0x00, 0x80}, {0x00, 0x80, 0x80}, {0xc0, 0xc0, 0xc0},
+		{0x80, 0x80, 0x80}, {0xff, 0x00, 0x00}, {0x00, 0xff, 0x00}, {0xff, 0xff, 0x00},
+		{0x00, 0x00, 0xff}, {0xff, 0x00, 0xff}, {0x00, 0xff, 0xff}, {0xff, 0xff, 0xff},
+	})
+	for i := 16; i < len(ret); i++ {
+		ret[i], _ = parseXColor(ansiColorPalette[i])
+	}
+	return ret
+}()
+
 var ansiColorPalette = [256]string{
 	"black", "maroon", "green", "olive", "navy", "purple", "teal", "silver",
 	"gray", "red", "lime", "yellow", "blue", "fuchsia", "aqua", "white",
@@ -0,0 +1,66 @@
+package terminal
+
+import "testing"
+
+func TestToANSI256(t *testing.T) {
+	tests := []struct {
+		c    RGBColor
+		want ANSIColor
+	}{
+		{RGBColor{0x00, 0x00, 0x00}, 16},
+		{RGBColor{0xff, 0xff, 0xff}, 231},
+		{RGBColor{0xff, 0x00, 0x00}, 196},
+		{RGBColor{0x5f, 0x87, 0xaf}, 67},
+		{RGBColor{0x80, 0x80, 0x80}, 244},
+		{RGBColor{0x08, 0x08, 0x08}, 232},
+		{RGBColor{0xee, 0xee, 0xee}, 255},
+		{RGBColor{0x60, 0x88, 0xb0}, 67}, // rounds to the nearest cube color
+	}
+	for _, test := range tests {
+		if got := test.c.ToANSI256(); got != test.want {
+			t.Errorf("%v.ToANSI256() = %d, want %d", test.c, got, test.want)
+		}
+	}
+}
+
+func TestRGB(t *testing.T) {
+	if got, want := NewRGBColor(Red.RGB()), (RGBColor{0x80, 0x00, 0x00}); got != want {
+		t.Errorf("NewRGBColor(Red.RGB()) = %v, want %v", got, want)
+	}
+	if r, g, b := NewRGBColor(1, 2, 3).RGB(); r != 1 || g != 2 || b != 3 {
+		t.Errorf("NewRGBColor(1, 2, 3).RGB() = %d, %d, %d", r, g, b)
+	}
+}
+
+func TestWithContrast(t *testing.T) {
+	black, white, gray := RGBColor{}, RGBColor{0xff, 0xff, 0xff}, RGBColor{0x80, 0x80, 0x80}
+	tests := []struct {
+		name      string
+		c, bg     RGBColor
+		ratio     float64
+		want      RGBColor
+		wantRatio bool // whether the result should reach the ratio
+	}{
+		{"already enough", white, black, 4.5, white, true},
+		{"darkened on white", RGBColor{0xaa, 0xaa, 0xff}, white, 4.5, RGBColor{0x71, 0x71, 0xaa}, true},
+		{"lightened on black", RGBColor{0x20, 0x20, 0x60}, black, 4.5, RGBColor{0x71, 0x71, 0x99}, true},
+		{"same color", gray, gray, 3, RGBColor{0xe1, 0xe1, 0xe1}, true},
+		{"impossible", gray, gray, 21, black, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := test.c.WithContrast(test.bg, test.ratio)
+			if got != test.want {
+				t.Errorf("WithContrast = %v, want %v", got, test.want)
+			}
+			ratio := ContrastRatio(got, test.bg)
+			if (ratio >= test.ratio) != test.wantRatio {
+				t.Errorf("contrast ratio = %.2f, want >= %.1f: %v", ratio, test.ratio, test.wantRatio)
+			}
+			// The color should change as little as possible, so it shouldn't overshoot by much.
+			if test.c != got && test.wantRatio && ratio > test.ratio*1.05 {
+				t.Errorf("contrast ratio = %.2f, want close to %.1f", ratio, test.ratio)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package terminal
+
+import (
+	"strings"
+)
+
+// Shells with semantic prompt integration (OSC 133, from FinalTerm) mark where the prompt starts (A), where the
+// command line starts (B), where the command's output starts (C) and when the command finished (D;<exit code>).
+// See https://gitlab.freedesktop.org/Per_Bothner/specifications/blob/master/proposals/semantic-prompts.md
+//
+// We use the marks to split the scrollback into command blocks.
+
+// CommandBlock is a command that was run at a shell prompt.
+type CommandBlock struct {
+	PromptLine int    `json:"promptLine"` // ID of the line the prompt starts on
+	Prompt     string `json:"prompt"`
+	Command    string `json:"command"`
+	Cwd        string `json:"cwd,omitempty"` // the shell's working directory, if it reported one (see cwd.go)
+
+	// Output is in lines [OutputStart, OutputEnd). Both are -1 until known.
+	OutputStart int `json:"outputStart"`
+	OutputEnd   int `json:"outputEnd"`
+
+	Finished bool `json:"finished"`
+	ExitCode int  `json:"exitCode"` // -1 if unknown
+}
+
+// position is a cursor position: a line ID and a column.
+type position struct {
+	line, col int
+}
+
+func (s *screen) cursorPosition() position {
+	return position{s.evicted + len(s.scrollback), s.pos}
+}
+
+// lineRunes returns the text of the line with the given ID, or nil if it was evicted.
+func (s *screen) lineRunes(id int) []rune {
+	i := id - s.evicted
+	switch {
+	case i < 0 || i > len(s.scrollback):
+		return nil
+	case i == len(s.scrollback):
+		runes := make([]rune, len(s.activeLine))
+		for j, n := range s.activeLine {
+			runes[j] = n.rune
+		}
+		return runes
+	default:
+		return []rune(s.scrollback[i].text)
+	}
+}
+
+// textBetween returns the text from one cursor position up to another, with lines separated by newlines.
+func (s *screen) textBetween(from, to position) string {
+	var text strings.Builder
+	for id := from.line; id <= to.line; id++ {
+		runes := s.lineRunes(id)
+		start, end := 0, len(runes)
+		if id == from.line {
+			start = clamp(from.col, 0, len(runes))
+		}
+		if id == to.line {
+			end = clamp(to.col, start, len(runes))
+		}
+		text.WriteString(string(runes[start:end]))
+		if id != to.line {
+			text.WriteByte('\n')
+		}
+	}
+	return text.String()
+}
+
+// The command block in progress is kept separately from finished ones, so that snapshots can share the finished
+// blocks without copying them.
+
+func (s *screen) finishCommand() {
+	if s.activeCommand == nil {
+		return
+	}
+	s.commands = append(s.commands, *s.activeCommand)
+	s.activeCommand = nil
+	s.commandPositions = commandPositions{}
+}
+
+// commandPositions are the marks of the active command block.
+type commandPositions struct {
+	prompt, command position
+}
+
+func (s *screen) markPromptStart() {
+	s.finishCommand()
+	pos := s.cursorPosition()
+	s.activeCommand = &CommandBlock{PromptLine: pos.line, OutputStart: -1, OutputEnd: -1, ExitCode: -1}
+	if s.cwd != nil {
+		s.activeCommand.Cwd = s.cwd.path
+	}
+	s.commandPositions = commandPositions{prompt: pos, command: pos}
+}
+
+func (s *screen) markCommandStart() {
+	if s.activeCommand == nil {
+		s.markPromptStart()
+	}
+	pos := s.cursorPosition()
+	s.activeCommand.Prompt = s.textBetween(s.commandPositions.prompt, pos)
+	s.commandPositions.command = pos
+}
+
+func (s *screen) markOutputStart() {
+	if s.activeCommand == nil {
+		s.markPromptStart()
+	}
+	pos := s.cursorPosition()
+	if s.activeCommand.Command == "" { // may have been reported by the shell already
+		s.activeCommand.Command = strings.TrimSpace(s.textBetween(s.commandPositions.command, pos))
+	}
+	s.activeCommand.OutputStart = pos.line
+}
+
+func (s *screen) markCommandFinished(exitCode int) {
+	if s.activeCommand == nil {
+		return
+	}
+	if s.activeCommand.OutputStart >= 0 {
+		pos := s.cursorPosition()
+		s.activeCommand.OutputEnd = pos.line
+		if pos.col > 0 {
+			s.activeCommand.OutputEnd++
+		}
+	}
+	s.activeCommand.Finished = true
+	s.activeCommand.ExitCode = exitCode
+	s.finishCommand()
+}
+
+// Commands returns the command blocks reported by shell integration, oldest first. The last one may still be
+// running. The returned slice is a copy, since the finished blocks are shared with the screen.
+func (sn Snapshot) Commands() []CommandBlock {
+	ret := make([]CommandBlock, len(sn.commands), len(sn.commands)+1)
+	copy(ret, sn.commands)
+	if sn.activeCommand != nil {
+		ret = append(ret, *sn.activeCommand)
+	}
+	return ret
+}
+
+// Commands is shorthand for Snapshot().Commands().
+func (s *screen) Commands() []CommandBlock {
+	return s.Snapshot().Commands()
+}
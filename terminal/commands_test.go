@@ -0,0 +1,77 @@
+package terminal
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCommandBlocks(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []CommandBlock
+	}{
+		{"finished and running", "\x1b]133;A\x07$ \x1b]133;B\x07ls -l\r\n\x1b]133;C\x07out1\r\nout2\r\n\x1b]133;D;0\x07" +
+			"\x1b]133;A\x07$ \x1b]133;B\x07sleep 10\r\n\x1b]133;C\x07partial",
+			[]CommandBlock{
+				{PromptLine: 0, Prompt: "$ ", Command: "ls -l", OutputStart: 1, OutputEnd: 3, Finished: true, ExitCode: 0},
+				{PromptLine: 3, Prompt: "$ ", Command: "sleep 10", OutputStart: 4, OutputEnd: -1, ExitCode: -1},
+			}},
+		// Output that doesn't end with a newline includes the line the cursor is on.
+		{"unterminated output", "\x1b]133;A\x07$ \x1b]133;B\x07false\r\n\x1b]133;C\x07out\x1b]133;D;1\x07",
+			[]CommandBlock{
+				{PromptLine: 0, Prompt: "$ ", Command: "false", OutputStart: 1, OutputEnd: 2, Finished: true, ExitCode: 1},
+			}},
+		{"missing exit code", "\x1b]133;A\x07$ \x1b]133;B\x07x\r\n\x1b]133;C\x07\x1b]133;D\x07",
+			[]CommandBlock{
+				{PromptLine: 0, Prompt: "$ ", Command: "x", OutputStart: 1, OutputEnd: 1, Finished: true, ExitCode: -1},
+			}},
+		{"invalid exit code", "\x1b]133;A\x07$ \x1b]133;B\x07x\r\n\x1b]133;C\x07\x1b]133;D;abc\x07",
+			[]CommandBlock{
+				{PromptLine: 0, Prompt: "$ ", Command: "x", OutputStart: 1, OutputEnd: 1, Finished: true, ExitCode: -1},
+			}},
+		// A command that was cancelled at the prompt has no output.
+		{"no output", "\x1b]133;A\x07$ \x1b]133;B\x07^C\r\n\x1b]133;D;130\x07",
+			[]CommandBlock{
+				{PromptLine: 0, Prompt: "$ ", OutputStart: -1, OutputEnd: -1, Finished: true, ExitCode: 130},
+			}},
+		{"multi-line prompt", "\x1b]133;A\x07~/src\r\n$ \x1b]133;B\x07  make  \r\n\x1b]133;C\x07",
+			[]CommandBlock{
+				{PromptLine: 0, Prompt: "~/src\n$ ", Command: "make", OutputStart: 2, OutputEnd: -1, ExitCode: -1},
+			}},
+		{"missing prompt start", "$ \x1b]133;B\x07x\r\n\x1b]133;C\x07",
+			[]CommandBlock{
+				{PromptLine: 0, Command: "x", OutputStart: 1, OutputEnd: -1, ExitCode: -1},
+			}},
+		{"command line reported by the shell", "\x1b]133;A\x07$ \x1b]133;B\x07\x1b]633;E;echo a\\x3bb\x07echo a;b\r\n" +
+			"\x1b]133;C\x07",
+			[]CommandBlock{
+				{PromptLine: 0, Prompt: "$ ", Command: "echo a;b", OutputStart: 1, OutputEnd: -1, ExitCode: -1},
+			}},
+		{"finished without a block", "\x1b]133;D;0\x07", []CommandBlock{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			term := newTestTerminal(nil)
+			feed(term, test.input)
+			if got := term.Snapshot().Commands(); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Commands() =\n%+v\nwant\n%+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestCommandsAreCopies(t *testing.T) {
+	term := newTestTerminal(nil)
+	feed(term, "\x1b]133;A\x07$ \x1b]133;B\x07x\r\n\x1b]133;C\x07\x1b]133;D;0\x07\x1b]1337;SetMark\x07")
+
+	snap := term.Snapshot()
+	snap.Commands()[0].Command = "modified"
+	snap.Marks()[0] = 100
+	if got := term.Snapshot().Commands()[0].Command; got != "x" {
+		t.Errorf("Commands()[0].Command = %q after modifying a returned slice, want x", got)
+	}
+	if got := term.Snapshot().Marks()[0]; got != 1 {
+		t.Errorf("Marks()[0] = %d after modifying a returned slice, want 1", got)
+	}
+}
@@ -0,0 +1,106 @@
+package terminal
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Shells report their working directory with OSC 7 (file://host/path), or with OSC 633 P;Cwd=path. When a line is
+// finalised, relative paths in it (compiler errors, ls) are resolved against the working directory at that point, so
+// they can be linked to the right file. The result is cached on the line, so files are only checked once per line
+// rather than on every render. See WithPathLinks.
+
+// workingDir is a directory on host, or on this host if host is "".
+type workingDir struct {
+	host, path string
+}
+
+// parseCwdURI parses the file: URI from OSC 7. The path is percent-decoded.
+func parseCwdURI(uri string) (*workingDir, bool) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" || u.Path == "" {
+		return nil, false
+	}
+	return &workingDir{host: u.Host, path: u.Path}, true
+}
+
+// setCwd records the shell's working directory. It's also attached to the active command block, as long as the
+// command hasn't started running yet.
+func (s *screen) setCwd(cwd *workingDir) {
+	s.cwd = cwd
+	if s.activeCommand != nil && s.activeCommand.OutputStart < 0 {
+		s.activeCommand.Cwd = cwd.path
+	}
+}
+
+// Cwd returns the shell's working directory, or "" if it hasn't reported one.
+func (sn Snapshot) Cwd() string {
+	if sn.cwd == nil {
+		return ""
+	}
+	return sn.cwd.path
+}
+
+// maxPathCandidates limits how many words of a line are checked for being paths, since each one is a stat.
+const maxPathCandidates = 64
+
+var pathCandidate = regexp.MustCompile(`[\w.+@%/-]+`)
+
+// pathLink is a path in a line's text, at text[start:end], as a file: URI.
+type pathLink struct {
+	start, end int
+	uri        string
+}
+
+// findPathLinks returns the words of text that are paths to existing files, relative to cwd or absolute. Paths can
+// only be checked on this host. Words end at characters that can't be part of a path in typical output, like the
+// ':' in "main.go:12:3: error". Words without a slash are only linked if they're regular files, since ordinary words
+// often match directory names.
+func findPathLinks(text string, cwd *workingDir) []pathLink {
+	if cwd == nil || !isLocalHost(cwd.host) {
+		return nil
+	}
+
+	var ret []pathLink
+	for _, loc := range pathCandidate.FindAllStringIndex(text, maxPathCandidates) {
+		word := strings.TrimRight(text[loc[0]:loc[1]], ".")
+		if strings.Trim(word, "/") == "" || strings.HasPrefix(word, "//") { // e.g. "/" or the rest of a URL
+			continue
+		}
+		path := word
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(cwd.path, path)
+		}
+		info, err := os.Stat(path)
+		if err != nil || (!strings.Contains(word, "/") && !info.Mode().IsRegular()) {
+			continue
+		}
+		u := url.URL{Scheme: "file", Path: path}
+		ret = append(ret, pathLink{loc[0], loc[0] + len(word), u.String()})
+	}
+	return ret
+}
+
+// writePathLinks writes text, which starts at offset in its line, with links for the paths among links.
+func (r htmlRenderer) writePathLinks(raw *strings.Builder, text string, offset int, links []pathLink) {
+	pos := 0
+	for _, l := range links {
+		start, end := l.start-offset, l.end-offset
+		href := r.links.href(l.uri)
+		if end <= 0 || start >= len(text) || href == "" {
+			continue
+		}
+		// A path split across spans is linked in each span.
+		start, end = clamp(start, pos, len(text)), clamp(end, 0, len(text))
+		raw.WriteString(html.EscapeString(text[pos:start]))
+		fmt.Fprintf(raw, "<a href=\"%s\" rel=\"noopener noreferrer\">%s</a>", html.EscapeString(href),
+			html.EscapeString(text[start:end]))
+		pos = end
+	}
+	raw.WriteString(html.EscapeString(text[pos:]))
+}
@@ -0,0 +1,60 @@
+package terminal
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPathLinks(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "src"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"main.go", "src/a.go"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cwd := (&url.URL{Scheme: "file", Path: dir}).String()
+
+	tests := []struct {
+		name, text string
+		want       []string
+	}{
+		{"relative file", "main.go:12:3: error", []string{"main.go"}},
+		{"relative path", "see src/a.go.", []string{"src/a.go"}},
+		{"directory with a slash", "cd src/", []string{"src/"}},
+		{"bare directory", "src", nil},
+		{"root", "cd /", nil},
+		{"absolute", "open " + filepath.Join(dir, "main.go"), []string{filepath.Join(dir, "main.go")}},
+		{"missing", "missing.go", nil},
+		{"url", "https://example.com/main.go", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got []string
+			for _, l := range findPathLinks(test.text, &workingDir{path: dir}) {
+				got = append(got, test.text[l.start:l.end])
+			}
+			if strings.Join(got, ",") != strings.Join(test.want, ",") {
+				t.Errorf("findPathLinks(%q) = %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+
+	t.Run("finalised lines", func(t *testing.T) {
+		term := newTestTerminal(strings.NewReader("\x1b]7;"+cwd+"\x07main.go\nmain.go"), WithPathLinks())
+		for term.parser.Continue() == nil {
+		}
+		lines := term.Snapshot().Lines()
+		if !strings.Contains(lines[0], "<a href=") {
+			t.Errorf("finished line = %q, want a link", lines[0])
+		}
+		if strings.Contains(lines[1], "<a href=") {
+			t.Errorf("active line = %q, want no link until it's finished", lines[1])
+		}
+	})
+}
@@ -0,0 +1,59 @@
+package terminal
+
+import "sort"
+
+// Event is something the application did that a client may want to react to, other than changing the screen's
+// content. Events are passed to the hook set with WithEventHook, and are also included in Changes so that polling
+// clients see them.
+type Event interface {
+	EventType() string
+}
+
+// maxEvents is how many recent events are kept for ChangesSince.
+const maxEvents = 256
+
+type eventRecord struct {
+	rev uint64
+	Event
+}
+
+// emit records an event. Like scrollback lines, recorded events are never modified, so snapshots can share them.
+func (s *screen) emit(ev Event) {
+	s.rev++
+	if len(s.events) >= maxEvents {
+		s.events = s.events[len(s.events)-maxEvents+1:]
+	}
+	s.events = append(s.events, eventRecord{s.rev, ev})
+	s.pendingEvents = append(s.pendingEvents, ev)
+	s.hasPendingEvents.Store(true)
+}
+
+// eventsSince returns the recorded events after revision rev.
+func (sn Snapshot) eventsSince(rev uint64) []eventRecord {
+	first := sort.Search(len(sn.events), func(i int) bool {
+		return sn.events[i].rev > rev
+	})
+	return sn.events[first:]
+}
+
+// flushEvents passes pending events to the event hook. It must be called without holding the screen lock, so the
+// hook can take snapshots. It's called after every read, so it only takes the lock if there are events to flush.
+func (t *RichTextTerminal) flushEvents() {
+	if !t.screen.hasPendingEvents.Load() {
+		return
+	}
+	t.screen.mu.Lock()
+	pending := t.screen.pendingEvents
+	t.screen.pendingEvents = nil
+	t.screen.hasPendingEvents.Store(false)
+	t.screen.mu.Unlock()
+
+	if t.eventHook == nil {
+		return
+	}
+	t.eventHookMu.Lock()
+	defer t.eventHookMu.Unlock()
+	for _, ev := range pending {
+		t.eventHook(ev)
+	}
+}
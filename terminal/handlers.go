@@ -7,38 +7,77 @@ package terminal
 import (
 	"log"
 	"strconv"
+	"strings"
 
 	"terminal_parser/ascii"
 )
 
 // TODO: Add a mode where we log unhandled escape sequences.
 
+// Every handler locks the screen for the duration of a single event, so that snapshots never observe a half-applied
+// escape sequence.
+
 func (t *RichTextTerminal) printRune(r rune) {
+	t.screen.mu.Lock()
+	defer t.screen.mu.Unlock()
+
 	t.screen.print(r)
 }
 
 func (t *RichTextTerminal) handleCtrl(c byte) {
+	t.screen.mu.Lock()
+	defer t.screen.mu.Unlock()
+
 	switch c {
 	case '\t':
-		t.printRune('\t')
+		t.screen.print('\t')
 	case '\a':
+		t.screen.ringBell()
 	case '\b':
 		t.screen.left(1) // We don't question things...
 	case ascii.DEL:
 		t.screen.backspace()
 	case '\r':
 		t.screen.cr()
+	case ascii.SO:
+		t.screen.charsets.shift(1)
+	case ascii.SI:
+		t.screen.charsets.shift(0)
 	case '\n', '\f', '\v', ascii.NEL:
 		t.screen.newline()
 	}
 }
 
 func (t *RichTextTerminal) handleEsc(intermediates string, final byte) {
+	t.screen.mu.Lock()
+	defer t.screen.mu.Unlock()
+
 	if intermediates == "" {
 		switch final {
+		case '7': // Save Cursor (DECSC)
+			t.screen.saveCursor()
+		case '8': // Restore Cursor (DECRC)
+			t.screen.restoreCursor()
 		case 'c': // Full Reset (RIS)
 			t.screen.newline()
 			t.screen.resetAttributes()
+			t.screen.resetCursorState()
+		case 'n': // Locking Shift 2 (LS2)
+			t.screen.charsets.shift(2)
+		case 'o': // Locking Shift 3 (LS3)
+			t.screen.charsets.shift(3)
+		}
+	}
+	if len(intermediates) == 1 {
+		switch intermediates[0] {
+		case '(': // Designate G0 Character Set (SCS)
+			t.screen.charsets.designate(0, final)
+		case ')', '-': // Designate G1 Character Set (SCS)
+			t.screen.charsets.designate(1, final)
+		case '*', '.': // Designate G2 Character Set (SCS)
+			t.screen.charsets.designate(2, final)
+		case '+', '/': // Designate G3 Character Set (SCS)
+			t.screen.charsets.designate(3, final)
 		}
 	}
 }
@@ -48,20 +87,23 @@ func (t *RichTextTerminal) handleCSI(params []string, intermediates string, fina
 		panic("CSI handler received nil param slice")
 	}
 
+	t.screen.mu.Lock()
+	defer t.screen.mu.Unlock()
+
 	var err error
 	var nParams []int
 	convertParamsWithDefault := func(defaultValue int) {
 		nParams = make([]int, len(params))
 		for i := 0; i < len(params); i++ {
-			if params[i] == "" {
+			param, _, _ := strings.Cut(params[i], ":") // only SGR uses subparameters
+			if param == "" {
 				nParams[i] = defaultValue
 				continue
 			}
-			nParams[i], err = strconv.Atoi(params[i])
+			nParams[i], err = strconv.Atoi(param)
 			if err != nil {
 				log.Printf("params: %q, int: %q, final: %q", params, intermediates, final)
 				panic("CSI handler received non-integer param")
-				return
 			}
 		}
 	}
@@ -99,10 +141,43 @@ func (t *RichTextTerminal) handleCSI(params []string, intermediates string, fina
 			}
 			t.screen.setPos(nParams[0]-1, nParams[1]-1)
 		case 'm': // Select Graphic Rendition (SGR)
+			for len(params) > 0 {
+				// Params with colon-separated subparameters are self-contained, e.g. 4:3 or 38:2::255:0:0.
+				// Everything else is handled as a run, since 38;5;n etc. spread one attribute across several params.
+				if strings.Contains(params[0], ":") {
+					t.handleSGRSubparams(strings.Split(params[0], ":"))
+					params = params[1:]
+					continue
+				}
+				n := 1
+				for n < len(params) && !strings.Contains(params[n], ":") {
+					n++
+				}
+				run := params[:n]
+				params = params[n:]
+				nParams = make([]int, len(run))
+				for i := range run {
+					nParams[i], _ = strconv.Atoi(run[i]) // the parser only collects digits, so "" is the only error
+				}
+				for len(nParams) > 0 {
+					handled := t.handleSGR(nParams)
+					nParams = nParams[handled:]
+				}
+			}
+		case 's': // Save Cursor (SCOSC)
+			t.screen.saveCursor()
+		case 'u': // Restore Cursor (SCORC)
+			t.screen.restoreCursor()
+		case 't': // Window Manipulation (XTWINOPS)
 			convertParamsWithDefault(0)
-			for len(nParams) > 0 {
-				handled := t.handleSGR(nParams)
-				nParams = nParams[handled:]
+			if len(nParams) == 1 {
+				nParams = append(nParams, titleAndIconName)
+			}
+			switch nParams[0] {
+			case 22: // Push Title
+				t.screen.pushTitle(nParams[1])
+			case 23: // Pop Title
+				t.screen.popTitle(nParams[1])
 			}
 		}
 	}
@@ -113,7 +188,17 @@ func (t *RichTextTerminal) handleCSI(params []string, intermediates string, fina
 			for _, param := range nParams {
 				switch param {
 				case 47, 1049: // Alternate screen buffer, SMCUP
-					t.upgrade()
+					t.upgraded = true // Run performs the upgrade once this event has been handled
+				case 1048: // Save cursor as in DECSC
+					t.screen.saveCursor()
+				}
+			}
+		case 'l': // Reset Mode (RM)
+			convertParamsWithDefault(0)
+			for _, param := range nParams {
+				switch param {
+				case 1048: // Restore cursor as in DECRC
+					t.screen.restoreCursor()
 				}
 			}
 		}
@@ -122,6 +207,7 @@ func (t *RichTextTerminal) handleCSI(params []string, intermediates string, fina
 		switch final {
 		case 'p': // Soft Terminal Reset
 			t.screen.resetAttributes()
+			t.screen.resetCursorState()
 		}
 	}
 }
@@ -141,7 +227,7 @@ func (t *RichTextTerminal) handleSGR(nParams []int) (handled int) {
 
 	switch nParams[0] {
 	case 0:
-		t.screen.resetAttributes() // TODO: apparently this isn't supposed to reset hyperlinks
+		t.screen.resetGraphicRendition()
 	case 1:
 		t.screen.setStyle(Bold)
 	case 2:
@@ -149,25 +235,33 @@ func (t *RichTextTerminal) handleSGR(nParams []int) (handled int) {
 	case 3:
 		t.screen.setStyle(Italic)
 	case 4:
-		t.screen.setStyle(Underline)
-	case 5, 6:
+		t.screen.setUnderline(Underline)
+	case 5:
 		t.screen.setStyle(Blink)
+	case 6:
+		t.screen.setStyle(RapidBlink)
 	case 7:
 		t.screen.setStyle(Inverted)
 	case 8:
 		t.screen.setStyle(Hidden)
 	case 9:
 		t.screen.setStyle(Strikethrough)
+	case 10, 11, 12, 13, 14, 15, 16, 17, 18, 19:
+		t.screen.setFont(uint8(nParams[0] - 10))
+	case 20:
+		t.screen.setStyle(Fraktur)
 	case 21:
-		t.screen.resetStyle(Bold)
+		t.screen.setUnderline(DoubleUnderline)
 	case 22:
-		t.screen.resetStyle(Dim)
+		t.screen.resetStyle(Bold | Dim)
 	case 23:
-		t.screen.resetStyle(Italic)
+		t.screen.resetStyle(Italic | Fraktur)
 	case 24:
-		t.screen.resetStyle(Underline)
+		t.screen.setUnderline(0)
 	case 25:
-		t.screen.resetStyle(Blink)
+		t.screen.resetStyle(Blink | RapidBlink)
+	case 26:
+		t.screen.setStyle(ProportionalSpacing)
 	case 27:
 		t.screen.resetStyle(Inverted)
 	case 28:
@@ -182,6 +276,36 @@ func (t *RichTextTerminal) handleSGR(nParams []int) (handled int) {
 		return handleColorSeq(t.screen.setBg)
 	case 49:
 		t.screen.resetBg()
+	case 50:
+		t.screen.resetStyle(ProportionalSpacing)
+	case 51:
+		t.screen.setStyle(Framed)
+		t.screen.resetStyle(Encircled)
+	case 52:
+		t.screen.setStyle(Encircled)
+		t.screen.resetStyle(Framed)
+	case 53:
+		t.screen.setStyle(Overline)
+	case 54:
+		t.screen.resetStyle(Framed | Encircled)
+	case 55:
+		t.screen.resetStyle(Overline)
+	case 58:
+		return handleColorSeq(t.screen.setUnderlineColor)
+	case 59:
+		t.screen.resetUnderlineColor()
+	case 60:
+		t.screen.setStyle(IdeogramUnderline)
+	case 61:
+		t.screen.setStyle(IdeogramDoubleUnderline)
+	case 62:
+		t.screen.setStyle(IdeogramOverline)
+	case 63:
+		t.screen.setStyle(IdeogramDoubleOverline)
+	case 64:
+		t.screen.setStyle(IdeogramStress)
+	case 65:
+		t.screen.resetStyle(ideogramStyles)
 	case 73:
 		t.screen.setStyle(Superscript)
 		t.screen.resetStyle(Subscript)
@@ -204,23 +328,235 @@ func (t *RichTextTerminal) handleSGR(nParams []int) (handled int) {
 	return 1
 }
 
-func (t *RichTextTerminal) handleDSC(params []string, intermediates string, final byte) {}
+// handleSGRSubparams handles a single SGR param with colon-separated subparameters (ITU T.416 style), as used for
+// extended underline styles (kitty, VTE, xterm) and for colors, e.g. 4:3 for a curly underline, 38:5:n, or
+// 58:2:<colorspace>:r:g:b. The colorspace is optional in practice, so 38:2:r:g:b is accepted too.
+func (t *RichTextTerminal) handleSGRSubparams(subparams []string) {
+	nSubparams := make([]int, len(subparams))
+	for i := range subparams {
+		nSubparams[i], _ = strconv.Atoi(subparams[i]) // "" defaults to 0
+	}
+
+	handleColor := func(setColor func(Color)) {
+		switch {
+		case len(nSubparams) >= 3 && nSubparams[1] == 5:
+			setColor(ANSIColor(nSubparams[2]))
+		case len(nSubparams) >= 6 && nSubparams[1] == 2:
+			setColor(RGBColor{uint8(nSubparams[3]), uint8(nSubparams[4]), uint8(nSubparams[5])})
+		case len(nSubparams) == 5 && nSubparams[1] == 2:
+			setColor(RGBColor{uint8(nSubparams[2]), uint8(nSubparams[3]), uint8(nSubparams[4])})
+		}
+	}
+
+	switch nSubparams[0] {
+	case 4:
+		if len(nSubparams) < 2 {
+			t.screen.setUnderline(Underline)
+			break
+		}
+		switch nSubparams[1] {
+		case 0:
+			t.screen.setUnderline(0)
+		case 1:
+			t.screen.setUnderline(Underline)
+		case 2:
+			t.screen.setUnderline(DoubleUnderline)
+		case 3:
+			t.screen.setUnderline(CurlyUnderline)
+		case 4:
+			t.screen.setUnderline(DottedUnderline)
+		case 5:
+			t.screen.setUnderline(DashedUnderline)
+		}
+	case 38:
+		handleColor(t.screen.setFg)
+	case 48:
+		handleColor(t.screen.setBg)
+	case 58:
+		handleColor(t.screen.setUnderlineColor)
+	default:
+		t.handleSGR(nSubparams[:1])
+	}
+}
+
+func (t *RichTextTerminal) handleDSC(params []string, intermediates string, final byte) {
+	t.sixel = nil
+	if intermediates == "" && final == 'q' { // Sixel Graphics
+		t.sixel = newSixelDecoder()
+	}
+}
+
+// putDCS and unhookDCS don't need to lock the screen until the sequence ends, since DCS state is only accessed by the
+// terminal goroutine.
+
+func (t *RichTextTerminal) putDCS(data []byte) {
+	if t.sixel != nil {
+		t.sixel.write(data)
+	}
+}
+
+func (t *RichTextTerminal) unhookDCS() {
+	if t.sixel == nil {
+		return
+	}
+	decoded := t.sixel.image()
+	t.sixel = nil
+	if decoded == nil {
+		return
+	}
+	img, err := newPNGImage(decoded)
+	if err != nil {
+		log.Printf("could not encode sixel image: %v", err)
+		return
+	}
+
+	t.screen.mu.Lock()
+	defer t.screen.mu.Unlock()
+	t.screen.printImage(img)
+}
 
 func (t *RichTextTerminal) handleOSC(params []string) {
 	if len(params) == 0 {
 		return
 	}
+
+	defer t.flushReplies() // after unlocking, so that readers aren't blocked if the application isn't reading
+	t.screen.mu.Lock()
+	defer t.screen.mu.Unlock()
+
 	switch params[0] {
-	case "0": // Set Window Title
+	case "0": // Set Icon Name and Window Title
+		title := strings.Join(params[1:], ";")
+		t.screen.updateTitle(titleState{title, title})
+	case "1": // Set Icon Name
+		t.screen.setIconName(strings.Join(params[1:], ";"))
+	case "2": // Set Window Title
+		t.screen.setTitle(strings.Join(params[1:], ";"))
 	case "7": // Set Working Directory
+		if cwd, ok := parseCwdURI(strings.Join(params[1:], ";")); ok {
+			t.screen.setCwd(cwd)
+		}
 	case "8": // Hyperlink
 		if len(params) < 3 {
-			t.screen.resetURI()
+			t.screen.resetLink()
 			break
 		}
-		t.screen.setURI(params[2]) // includes "" to reset
+		uri := strings.Join(params[2:], ";") // URIs may contain semicolons
+		if uri == "" {
+			t.screen.resetLink()
+			break
+		}
+		t.screen.setLink(uri, parseLinkParams(params[1])["id"])
+	case "4": // Change/Query Color Number
+		for i := 1; i+1 < len(params); i += 2 {
+			n, err := strconv.Atoi(params[i])
+			if err != nil || n < 0 || n > 255 {
+				continue
+			}
+			if params[i+1] == "?" {
+				t.queueReply("\x1b]4;%d;%s\x1b\\", n, t.screen.renderer.palette.rgb(ANSIColor(n)).xColorSpec())
+			} else if c, ok := parseXColor(params[i+1]); ok {
+				t.screen.setPaletteColor(ANSIColor(n), c)
+			}
+		}
+	case "10", "11", "12": // Change/Query Default Foreground/Background/Cursor Color
+		// Each further param sets the next color, e.g. OSC 10;fg;bg
+		first, _ := strconv.Atoi(params[0])
+		for i, spec := range params[1:] {
+			n := first - 10 + i
+			if n > 2 {
+				break
+			}
+			if spec == "?" {
+				t.queueReply("\x1b]%d;%s\x1b\\", 10+n, t.screen.dynamicColorRGB(n).xColorSpec())
+			} else if c, ok := parseXColor(spec); ok {
+				t.screen.setDynamicColor(n, c)
+			}
+		}
+	case "104": // Reset Color Number
+		if len(params) == 1 || len(params) == 2 && params[1] == "" {
+			t.screen.resetPalette()
+			break
+		}
+		for _, param := range params[1:] {
+			if n, err := strconv.Atoi(param); err == nil && n >= 0 && n <= 255 {
+				t.screen.resetPaletteColor(ANSIColor(n))
+			}
+		}
+	case "110", "111", "112": // Reset Default Foreground/Background/Cursor Color
+		n, _ := strconv.Atoi(params[0])
+		t.screen.resetDynamicColor(n - 110)
+	case "9": // Post Notification (iTerm2), or ConEmu's extensions
+		t.handleITerm2Notification(params[1:])
+	case "777": // Extensions (urxvt)
+		if len(params) >= 3 && params[1] == "notify" {
+			t.screen.emit(Notification{Title: params[2], Body: strings.Join(params[3:], ";")})
+		}
+	case "99": // Desktop Notification (kitty)
+		t.handleKittyNotification(params[1:])
+	case "52": // Manipulate Selection Data
+		t.handleClipboard(params[1:])
 	case "133": // Semantic Prompt (FinalTerm)
+		t.handleSemanticPrompt(params[1:])
 	case "633": // Shell Integration (VSCode)
-	case "1337": // User Vars (iTerm2)
+		if len(params) < 2 {
+			break
+		}
+		switch params[1] {
+		case "A", "B", "C", "D": // Same as OSC 133
+			t.handleSemanticPrompt(params[1:])
+		case "E": // Command line, with an optional nonce
+			if len(params) < 3 {
+				break
+			}
+			if t.screen.nonce != "" && (len(params) < 4 || params[3] != t.screen.nonce) {
+				log.Print("ignoring OSC 633 command line with a missing or incorrect nonce")
+				break
+			}
+			t.screen.setCommandLine(unescapeVSCodeValue(params[2]))
+		case "P": // Property
+			if len(params) < 3 {
+				break
+			}
+			if key, value, ok := strings.Cut(params[2], "="); ok {
+				t.screen.setShellProperty(key, unescapeVSCodeValue(value))
+			}
+		}
+	case "1337": // Proprietary Escape Codes (iTerm2)
+		t.handleITerm2(params[1:])
+	}
+}
+
+// handleSemanticPrompt handles the params of OSC 133 after the "133", and the same marks in OSC 633.
+func (t *RichTextTerminal) handleSemanticPrompt(params []string) {
+	if len(params) == 0 {
+		return
+	}
+	switch params[0] {
+	case "A": // Prompt start
+		t.screen.markPromptStart()
+	case "B": // Command start
+		t.screen.markCommandStart()
+	case "C": // Output start
+		t.screen.markOutputStart()
+	case "D": // Command finished, with an optional exit code
+		exitCode := -1
+		if len(params) > 1 {
+			if n, err := strconv.Atoi(params[1]); err == nil {
+				exitCode = n
+			}
+		}
+		t.screen.markCommandFinished(exitCode)
+	}
+}
+
+// parseLinkParams parses the colon-separated key=value params of an OSC 8 hyperlink.
+func parseLinkParams(param string) map[string]string {
+	ret := map[string]string{}
+	for _, kv := range strings.Split(param, ":") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			ret[k] = v
+		}
 	}
+	return ret
 }
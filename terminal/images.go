@@ -0,0 +1,84 @@
+package terminal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"image"
+	_ "image/gif" // formats accepted in inline images
+	_ "image/jpeg"
+	"image/png"
+	"strings"
+)
+
+// Images from graphics protocols are placed in the active line as a single cell, which the HTML renderer emits as an
+// <img>. Like other attributes, an image is never modified once it's placed, so lines and snapshots can share it.
+
+// imageCell is the rune stored in an image's cell, so the line's text has a placeholder for it.
+const imageCell = '￼' // OBJECT REPLACEMENT CHARACTER
+
+type inlineImage struct {
+	src  string // a data: URI
+	name string
+
+	// The displayed size as CSS lengths, or "" for the image's own size.
+	width, height string
+	// stretch is set if both width and height are set and the image should fill them instead of keeping its aspect
+	// ratio.
+	stretch bool
+}
+
+// newInlineImage checks that data is an image in a format browsers can show. Other data, like SVG (which can contain
+// scripts) is rejected.
+func newInlineImage(data []byte) (*inlineImage, error) {
+	_, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported image: %w", err)
+	}
+	return &inlineImage{src: "data:image/" + format + ";base64," + base64.StdEncoding.EncodeToString(data)}, nil
+}
+
+// newPNGImage encodes img, which was decoded from a graphics protocol, as a PNG.
+func newPNGImage(img image.Image) (*inlineImage, error) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return &inlineImage{src: "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())}, nil
+}
+
+// printImage places img at the cursor, with the current attributes (e.g. a hyperlink).
+func (s *screen) printImage(img *inlineImage) {
+	s.touch()
+	attrs := *s.activeAttributes
+	attrs.image = img
+	n := node{imageCell, &attrs}
+	if s.pos < len(s.activeLine) {
+		s.activeLine[s.pos] = n
+	} else {
+		s.activeLine = append(s.activeLine, n)
+	}
+	s.pos++
+}
+
+// writeImages writes an <img> for each of the cells in text, which all have img.
+func writeImages(raw *strings.Builder, text string, img *inlineImage) {
+	var style string
+	if img.width != "" {
+		style += "width:" + img.width + ";"
+	}
+	if img.height != "" {
+		style += "height:" + img.height + ";"
+	}
+	if img.width != "" && img.height != "" && !img.stretch {
+		style += "object-fit:contain;"
+	}
+	for range text {
+		fmt.Fprintf(raw, "<img src=\"%s\" alt=\"%s\"", img.src, html.EscapeString(img.name))
+		if style != "" {
+			fmt.Fprintf(raw, " style=\"%s\"", style)
+		}
+		raw.WriteString(">")
+	}
+}
@@ -0,0 +1,74 @@
+package terminal
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// Without shell integration, we guess that an application is waiting for input when all of these are true:
+//   - it hasn't written anything for a while,
+//   - the pty is in canonical mode with echo on, i.e. the application is reading a line the way a shell or a simple
+//     prompt does (full-screen applications use raw mode, and password prompts turn echo off), and
+//   - the cursor is on a line with text on it that hasn't been ended with a newline, i.e. a prompt.
+
+// InputRequested is emitted when the application seems to be waiting for a line of input.
+type InputRequested struct {
+	Prompt string `json:"prompt"` // the text of the active line, e.g. "Do you want to continue? [Y/n] "
+	Line   int    `json:"line"`   // ID of the active line
+}
+
+func (InputRequested) EventType() string { return "inputRequested" }
+
+// outputArrived restarts the idle timer. It's called from the terminal goroutine whenever output arrives.
+func (t *RichTextTerminal) outputArrived() {
+	if t.inputIdle <= 0 || t.src == nil {
+		return
+	}
+	if t.inputTimer == nil {
+		t.inputTimer = time.AfterFunc(t.inputIdle, t.checkInputRequested)
+		return
+	}
+	t.inputTimer.Reset(t.inputIdle)
+}
+
+func (t *RichTextTerminal) stopInputDetection() {
+	if t.inputTimer != nil {
+		t.inputTimer.Stop()
+	}
+}
+
+// checkInputRequested runs on its own goroutine once output has been idle for a while.
+func (t *RichTextTerminal) checkInputRequested() {
+	t.screen.mu.Lock()
+	emitted := t.screen.checkInputRequested(t.src)
+	t.screen.mu.Unlock()
+
+	if emitted {
+		t.flushEvents()
+	}
+}
+
+func (s *screen) checkInputRequested(pty *os.File) bool {
+	if s.inputRequested || len(s.activeLine) == 0 {
+		return false
+	}
+	// Shell integration already tells the client about prompts.
+	if s.activeCommand != nil && s.activeCommand.OutputStart < 0 {
+		return false
+	}
+
+	canonical, echo, err := ptyLineMode(pty)
+	if err != nil {
+		log.Printf("could not read pty mode: %v", err)
+		return false
+	}
+	if !canonical || !echo {
+		return false
+	}
+
+	s.inputRequested = true // until the screen changes again
+	id := s.evicted + len(s.scrollback)
+	s.emit(InputRequested{Prompt: string(s.lineRunes(id)), Line: id})
+	return true
+}
@@ -0,0 +1,124 @@
+package terminal
+
+import (
+	"encoding/base64"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// iTerm2's proprietary escape codes are all OSC 1337;Key=Value. We support the ones that are about the shell's state,
+// and inline images (File=...), which imgcat uses. See https://iterm2.com/documentation-escape-codes.html and
+// https://iterm2.com/documentation-images.html
+
+// handleITerm2 handles the params of OSC 1337 after the "1337".
+func (t *RichTextTerminal) handleITerm2(params []string) {
+	if len(params) == 0 {
+		return
+	}
+	key, value, _ := strings.Cut(strings.Join(params, ";"), "=")
+	switch key {
+	case "SetUserVar": // SetUserVar=name=<base64 value>
+		name, encoded, ok := strings.Cut(value, "=")
+		if !ok {
+			break
+		}
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			log.Printf("ignoring user var %q with invalid value: %v", name, err)
+			break
+		}
+		t.screen.setUserVar(name, string(decoded))
+	case "SetMark":
+		t.screen.setMark()
+	case "CurrentDir":
+		t.screen.setCwd(&workingDir{host: t.screen.remoteHost, path: value})
+	case "RemoteHost": // RemoteHost=user@host
+		_, host, ok := strings.Cut(value, "@")
+		if !ok {
+			host = value
+		}
+		t.screen.remoteHost = host
+	case "File": // File=<args>:<base64 data>
+		args, encoded, ok := strings.Cut(value, ":")
+		if !ok {
+			break
+		}
+		t.handleITerm2File(args, encoded)
+	}
+}
+
+func (t *RichTextTerminal) handleITerm2File(args, encoded string) {
+	var inline, stretch bool
+	var name, width, height string
+	for _, arg := range strings.Split(args, ";") {
+		k, v, _ := strings.Cut(arg, "=")
+		switch k {
+		case "inline":
+			inline = v == "1"
+		case "name":
+			if decoded, err := base64.StdEncoding.DecodeString(v); err == nil {
+				name = string(decoded)
+			}
+		case "width":
+			width = iTerm2ImageSize(v, "ch")
+		case "height":
+			height = iTerm2ImageSize(v, "lh")
+		case "preserveAspectRatio":
+			stretch = v == "0"
+		}
+	}
+	if !inline {
+		// Without inline=1, iTerm2 offers the file as a download. We only show images.
+		return
+	}
+
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		log.Printf("ignoring inline image with invalid data: %v", err)
+		return
+	}
+	img, err := newInlineImage(data)
+	if err != nil {
+		log.Printf("ignoring inline image %q: %v", name, err)
+		return
+	}
+	img.name, img.width, img.height, img.stretch = name, width, height, stretch
+	t.screen.printImage(img)
+}
+
+// iTerm2ImageSize converts an image dimension (N cells, Npx, N% or auto) to a CSS length, using cellUnit for cells.
+func iTerm2ImageSize(size, cellUnit string) string {
+	unit := cellUnit
+	switch {
+	case strings.HasSuffix(size, "px"):
+		size, unit = strings.TrimSuffix(size, "px"), "px"
+	case strings.HasSuffix(size, "%"):
+		size, unit = strings.TrimSuffix(size, "%"), "%"
+	}
+	if n, err := strconv.Atoi(size); err != nil || n <= 0 {
+		return "" // including auto
+	}
+	return size + unit
+}
+
+// setUserVar records a user var.
+func (s *screen) setUserVar(name, value string) {
+	s.userVars = withEntry(s.userVars, name, value)
+}
+
+// setMark records a mark at the cursor's line, like iTerm2's Cmd-Shift-M.
+func (s *screen) setMark() {
+	s.marks = append(s.marks, s.cursorPosition().line)
+}
+
+// UserVars returns the variables set with iTerm2's SetUserVar, decoded. The returned map must not be modified.
+func (sn Snapshot) UserVars() map[string]string {
+	return sn.userVars
+}
+
+// Marks returns the IDs of the lines marked with iTerm2's SetMark, oldest first. The returned slice is a copy, since
+// the marks are shared with the screen.
+func (sn Snapshot) Marks() []int {
+	return append([]int(nil), sn.marks...)
+}
@@ -0,0 +1,496 @@
+package terminal
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Kitty's graphics protocol sends images as APC G<control data>;<payload> ST, where the control data is a list of
+// key=value pairs. Images are transmitted (possibly in chunks) and stored by ID, then placed at the cursor, and can be
+// deleted later. See https://sw.kovidgoyal.net/kitty/graphics-protocol/
+//
+// Placements become image cells in the active line, like inline images. Deleting a placement removes it from the
+// active line, but not from the scrollback, which is history.
+
+// Limits on transmitted and stored images. Stored images are evicted oldest first once there are too many, or once
+// they take up too much memory in total, decoded and encoded.
+const (
+	maxKittyImages      = 64
+	maxKittyStoredBytes = 256 << 20
+	maxKittyImageSize   = 64 << 20 // bytes, before and after decompression
+	// Sizes are checked before images are decoded, since a tiny PNG can declare a huge image.
+	maxKittyImageWidth  = 4096
+	maxKittyImageHeight = 4096
+)
+
+// kittyControl is the control data of a command, keyed by the single-character keys.
+type kittyControl map[byte]string
+
+func parseKittyControl(s string) kittyControl {
+	c := kittyControl{}
+	for _, kv := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(kv, "=")
+		if ok && len(k) == 1 {
+			c[k[0]] = v
+		}
+	}
+	return c
+}
+
+// int returns the numeric value of key, or 0 if it's missing or invalid.
+func (c kittyControl) int(key byte) int {
+	n, _ := strconv.Atoi(c[key])
+	return n
+}
+
+// char returns the single-character value of key, or def if it's missing.
+func (c kittyControl) char(key, def byte) byte {
+	if v := c[key]; len(v) == 1 {
+		return v[0]
+	}
+	return def
+}
+
+type kittyError struct {
+	code, msg string
+}
+
+func (e kittyError) Error() string {
+	return e.code + ":" + e.msg
+}
+
+type kittyPlacementKey struct {
+	image, placement int
+}
+
+type kittyImage struct {
+	decoded image.Image
+	full    *inlineImage // the whole image, encoded for display
+	size    int          // roughly how many bytes the image takes up, for maxKittyStoredBytes
+}
+
+// kittyState is only accessed by the terminal goroutine. Placements are kept on the screen instead, since they belong
+// to the active line.
+type kittyState struct {
+	images      map[int]*kittyImage
+	order       []int       // image IDs, oldest first, for eviction
+	storedBytes int         // the total size of images
+	numbers     map[int]int // image numbers (I) to the IDs they were assigned
+	nextID      int
+
+	// The chunked transfer in progress, if any. Later chunks only have the m (and q) keys. If a chunk is invalid, the
+	// rest of the transfer is still consumed, and the error is reported once it's complete.
+	pending     kittyControl
+	pendingData []byte
+	pendingErr  error
+}
+
+// handleAPC handles APC sequences, of which we only support kitty graphics.
+func (t *RichTextTerminal) handleAPC(data string) {
+	if !strings.HasPrefix(data, "G") {
+		return
+	}
+	controlData, payload, _ := strings.Cut(data[1:], ";")
+	c := parseKittyControl(controlData)
+
+	k := &t.kitty
+	if k.pending != nil {
+		// A continuation chunk. Errors are only reported once the transfer is complete.
+		if k.pendingErr == nil {
+			chunk, err := decodeKittyPayload(payload)
+			if err == nil && len(k.pendingData)+len(chunk) > maxKittyImageSize {
+				err = kittyError{"EFBIG", "image is too large"}
+			}
+			if err != nil {
+				k.pendingData, k.pendingErr = nil, err
+			} else {
+				k.pendingData = append(k.pendingData, chunk...)
+			}
+		}
+		if c.int('m') == 1 {
+			return
+		}
+		c, data, err := k.pending, k.pendingData, k.pendingErr
+		k.pending, k.pendingData, k.pendingErr = nil, nil, nil
+		if err != nil {
+			t.kittyReply(c, err)
+			return
+		}
+		t.kittyTransmit(c, data)
+		return
+	}
+
+	switch action := c.char('a', 't'); action {
+	case 't', 'T', 'q': // transmit, transmit and display, query
+		data, err := decodeKittyPayload(payload)
+		if c.int('m') == 1 {
+			k.pending, k.pendingData, k.pendingErr = c, data, err
+			return
+		}
+		if err != nil {
+			t.kittyReply(c, err)
+			return
+		}
+		t.kittyTransmit(c, data)
+	case 'p': // put
+		t.kittyReply(c, t.kittyPut(c))
+	case 'd': // delete
+		t.kittyDelete(c)
+	default: // animation
+		t.kittyReply(c, kittyError{"EINVAL", fmt.Sprintf("unsupported action %q", action)})
+	}
+}
+
+func decodeKittyPayload(payload string) ([]byte, error) {
+	// Chunks may or may not be padded.
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+	if err != nil {
+		return nil, kittyError{"EINVAL", "invalid base64 payload"}
+	}
+	return data, nil
+}
+
+// kittyTransmit handles a complete transmission, whose payload has been base64-decoded.
+func (t *RichTextTerminal) kittyTransmit(c kittyControl, data []byte) {
+	decoded, err := readKittyImage(c, data)
+	if err != nil {
+		t.kittyReply(c, err)
+		return
+	}
+	full, err := newPNGImage(decoded)
+	if err != nil {
+		t.kittyReply(c, kittyError{"EINVAL", err.Error()})
+		return
+	}
+	bounds := decoded.Bounds()
+	img := &kittyImage{decoded, full, bounds.Dx()*bounds.Dy()*4 + len(full.src)}
+
+	action := c.char('a', 't')
+	if action == 'q' { // only check that the image could be loaded
+		t.kittyReply(c, nil)
+		return
+	}
+
+	k := &t.kitty
+	if number := c.int('I'); number > 0 {
+		k.nextID++
+		for k.images[k.nextID] != nil || k.nextID == 0 {
+			k.nextID++
+		}
+		if k.numbers == nil {
+			k.numbers = map[int]int{}
+		}
+		k.numbers[number] = k.nextID
+		c['i'] = strconv.Itoa(k.nextID)
+	}
+	if id := c.int('i'); id > 0 {
+		k.store(id, img)
+	}
+
+	if action == 'T' {
+		err = t.kittyPlace(c, img)
+	}
+	t.kittyReply(c, err)
+}
+
+func (k *kittyState) store(id int, img *kittyImage) {
+	if k.images == nil {
+		k.images = map[int]*kittyImage{}
+	}
+	if old := k.images[id]; old != nil {
+		k.storedBytes -= old.size
+	} else {
+		k.order = append(k.order, id)
+	}
+	k.images[id] = img
+	k.storedBytes += img.size
+	for len(k.order) > maxKittyImages || k.storedBytes > maxKittyStoredBytes && len(k.order) > 1 {
+		k.storedBytes -= k.images[k.order[0]].size
+		delete(k.images, k.order[0])
+		k.order = k.order[1:]
+	}
+}
+
+// readKittyImage reads the image data from the transmission medium and decodes it.
+func readKittyImage(c kittyControl, data []byte) (image.Image, error) {
+	switch medium := c.char('t', 'd'); medium {
+	case 'd': // direct
+	case 'f', 't': // file, temporary file
+		var err error
+		if data, err = readKittyFile(string(data), c.int('O'), c.int('S'), medium == 't'); err != nil {
+			return nil, err
+		}
+	default: // shared memory isn't supported, since we may not be on the same machine as the viewer anyway
+		return nil, kittyError{"EINVAL", fmt.Sprintf("unsupported transmission medium %q", medium)}
+	}
+
+	if c.char('o', 0) == 'z' {
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, kittyError{"EINVAL", "invalid zlib data"}
+		}
+		if data, err = io.ReadAll(io.LimitReader(zr, maxKittyImageSize+1)); err != nil {
+			return nil, kittyError{"EINVAL", "invalid zlib data"}
+		}
+		if len(data) > maxKittyImageSize {
+			return nil, kittyError{"EFBIG", "image is too large"}
+		}
+	}
+
+	switch format := c.int('f'); format {
+	case 100: // PNG
+		config, err := png.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return nil, kittyError{"EBADPNG", err.Error()}
+		}
+		if config.Width > maxKittyImageWidth || config.Height > maxKittyImageHeight {
+			return nil, kittyError{"EFBIG", "image is too large"}
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, kittyError{"EBADPNG", err.Error()}
+		}
+		return img, nil
+	case 0, 24, 32: // raw RGB or RGBA
+		return kittyRawImage(data, c.int('s'), c.int('v'), format != 24)
+	default:
+		return nil, kittyError{"EINVAL", fmt.Sprintf("unsupported format %d", format)}
+	}
+}
+
+// readKittyFile reads size bytes (or to the end, if size is 0) at offset from a local file. Temporary files are
+// deleted afterwards, but only if they look like they were created for this purpose, as the protocol requires.
+func readKittyFile(path string, offset, size int, temporary bool) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, kittyError{"EBADF", err.Error()}
+	}
+	if !info.Mode().IsRegular() {
+		return nil, kittyError{"EBADF", "not a regular file"}
+	}
+	if temporary {
+		defer func() {
+			if strings.Contains(path, "tty-graphics-protocol") && filepath.Dir(path) == filepath.Clean(os.TempDir()) {
+				_ = os.Remove(path)
+			}
+		}()
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, kittyError{"EBADF", err.Error()}
+	}
+	defer f.Close()
+	if size <= 0 || size > maxKittyImageSize {
+		size = maxKittyImageSize + 1
+	}
+	data, err := io.ReadAll(io.NewSectionReader(f, int64(offset), int64(size)))
+	if err != nil {
+		return nil, kittyError{"EBADF", err.Error()}
+	}
+	if len(data) > maxKittyImageSize {
+		return nil, kittyError{"EFBIG", "image is too large"}
+	}
+	return data, nil
+}
+
+func kittyRawImage(data []byte, width, height int, alpha bool) (image.Image, error) {
+	bpp := 3
+	if alpha {
+		bpp = 4
+	}
+	if width <= 0 || height <= 0 {
+		return nil, kittyError{"EINVAL", "invalid image size"}
+	}
+	// Checked before multiplying, which could overflow.
+	if width > maxKittyImageWidth || height > maxKittyImageHeight {
+		return nil, kittyError{"EFBIG", "image is too large"}
+	}
+	if len(data) < width*height*bpp {
+		return nil, kittyError{"ENODATA", "insufficient image data"}
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for i := 0; i < width*height; i++ {
+		px := data[i*bpp:]
+		c := color.NRGBA{px[0], px[1], px[2], 255}
+		if alpha {
+			c.A = px[3]
+		}
+		img.SetNRGBA(i%width, i/width, c)
+	}
+	return img, nil
+}
+
+// kittyPut displays an image that was transmitted earlier.
+func (t *RichTextTerminal) kittyPut(c kittyControl) error {
+	k := &t.kitty
+	id := c.int('i')
+	if number := c.int('I'); number > 0 {
+		id = k.numbers[number]
+		c['i'] = strconv.Itoa(id)
+	}
+	img := k.images[id]
+	if img == nil {
+		return kittyError{"ENOENT", fmt.Sprintf("image %d not found", id)}
+	}
+	return t.kittyPlace(c, img)
+}
+
+// kittyPlace places img at the cursor.
+func (t *RichTextTerminal) kittyPlace(c kittyControl, img *kittyImage) error {
+	placed := *img.full
+	if x, y, w, h := c.int('x'), c.int('y'), c.int('w'), c.int('h'); x != 0 || y != 0 || w != 0 || h != 0 {
+		bounds := img.decoded.Bounds()
+		rect := image.Rect(x, y, x+w, y+h).Add(bounds.Min)
+		if w == 0 {
+			rect.Max.X = bounds.Max.X
+		}
+		if h == 0 {
+			rect.Max.Y = bounds.Max.Y
+		}
+		sub, ok := img.decoded.(interface {
+			SubImage(image.Rectangle) image.Image
+		})
+		if !ok || rect.Intersect(bounds).Empty() {
+			return kittyError{"EINVAL", "invalid source rectangle"}
+		}
+		cropped, err := newPNGImage(sub.SubImage(rect.Intersect(bounds)))
+		if err != nil {
+			return kittyError{"EINVAL", err.Error()}
+		}
+		placed = *cropped
+	}
+	if cols := c.int('c'); cols > 0 {
+		placed.width = strconv.Itoa(cols) + "ch"
+	}
+	if rows := c.int('r'); rows > 0 {
+		placed.height = strconv.Itoa(rows) + "lh"
+	}
+	placed.stretch = true // like kitty, c and r scale the image to fill the cells
+
+	t.screen.mu.Lock()
+	defer t.screen.mu.Unlock()
+
+	s := &t.screen
+	key := kittyPlacementKey{c.int('i'), c.int('p')}
+	if old := s.kittyPlacements[key]; old != nil && key.image != 0 {
+		// A placement with the same IDs replaces the old one.
+		s.removeImages(map[*inlineImage]bool{old: true})
+	}
+	if key.image != 0 {
+		if s.kittyPlacements == nil {
+			s.kittyPlacements = map[kittyPlacementKey]*inlineImage{}
+		}
+		s.kittyPlacements[key] = &placed
+	}
+	s.printImage(&placed)
+	return nil
+}
+
+// kittyDelete handles a=d. Lowercase specifiers delete placements, and uppercase ones also free the images' data.
+// Deleting by position isn't supported, since placements don't have a position on a grid here.
+func (t *RichTextTerminal) kittyDelete(c kittyControl) {
+	k := &t.kitty
+	what := c.char('d', 'a')
+	free := what >= 'A' && what <= 'Z'
+
+	id := c.int('i')
+	switch what {
+	case 'a', 'A':
+		id = 0
+	case 'i', 'I':
+	case 'n', 'N':
+		id = k.numbers[c.int('I')]
+	default:
+		return
+	}
+
+	if free {
+		for i, imageID := range k.order {
+			if id != 0 && imageID != id {
+				continue
+			}
+			k.storedBytes -= k.images[imageID].size
+			delete(k.images, imageID)
+			k.order[i] = 0
+		}
+		order := k.order[:0]
+		for _, imageID := range k.order {
+			if imageID != 0 {
+				order = append(order, imageID)
+			}
+		}
+		k.order = order
+	}
+
+	t.screen.mu.Lock()
+	defer t.screen.mu.Unlock()
+
+	s := &t.screen
+	remove := map[*inlineImage]bool{}
+	for key, placed := range s.kittyPlacements {
+		matches := id == 0 || key.image == id && (c.int('p') == 0 || key.placement == c.int('p'))
+		if matches {
+			remove[placed] = true
+			delete(s.kittyPlacements, key)
+		}
+	}
+	s.removeImages(remove)
+}
+
+// kittyReply responds to a command, unless it didn't have an ID or the client asked to be quiet.
+func (t *RichTextTerminal) kittyReply(c kittyControl, err error) {
+	id, number := c.int('i'), c.int('I')
+	if id == 0 && number == 0 {
+		return
+	}
+	quiet := c.int('q')
+	if err == nil && quiet >= 1 || err != nil && quiet >= 2 {
+		return
+	}
+
+	msg := "OK"
+	if err != nil {
+		var kerr kittyError
+		if !errors.As(err, &kerr) {
+			err = kittyError{"EINVAL", err.Error()}
+		}
+		msg = err.Error()
+	}
+	keys := "i=" + strconv.Itoa(id)
+	if number != 0 {
+		keys += ",I=" + strconv.Itoa(number)
+	}
+	if p := c.int('p'); p != 0 {
+		keys += ",p=" + strconv.Itoa(p)
+	}
+	t.reply("\x1b_G%s;%s\x1b\\", keys, msg)
+}
+
+// removeImages replaces the cells of the given images in the active line with blanks.
+func (s *screen) removeImages(imgs map[*inlineImage]bool) {
+	if len(imgs) == 0 {
+		return
+	}
+	for i, n := range s.activeLine {
+		if n.styleAttributes.image == nil || !imgs[n.styleAttributes.image] {
+			continue
+		}
+		s.touch()
+		attrs := *n.styleAttributes
+		attrs.image = nil
+		s.activeLine[i] = node{' ', &attrs}
+	}
+}
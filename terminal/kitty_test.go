@@ -0,0 +1,141 @@
+package terminal
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/gif"
+	"image/png"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func encodeImage(t *testing.T, encode func(io.Writer, image.Image) error) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := encode(&buf, image.NewNRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestKittyPNG(t *testing.T) {
+	small := encodeImage(t, func(w io.Writer, img image.Image) error { return png.Encode(w, img) })
+
+	// A PNG whose header declares a huge image, with a valid checksum, so only the size check rejects it.
+	huge := bytes.Clone(small)
+	binary.BigEndian.PutUint32(huge[16:], 200000)
+	binary.BigEndian.PutUint32(huge[20:], 200000)
+	binary.BigEndian.PutUint32(huge[29:], crc32.ChecksumIEEE(huge[12:29]))
+
+	gifData := encodeImage(t, func(w io.Writer, img image.Image) error { return gif.Encode(w, img, nil) })
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"valid", small, "OK"},
+		{"huge", huge, "EFBIG"},
+		{"gif", gifData, "EBADPNG"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			replies, _ := runWithReplies(t, "\x1b_Ga=t,f=100,i=1;"+base64.StdEncoding.EncodeToString(test.data)+"\x1b\\")
+			if !strings.Contains(replies, test.want) {
+				t.Errorf("reply = %q, want %s", replies, test.want)
+			}
+		})
+	}
+}
+
+func TestKittyChunkError(t *testing.T) {
+	// The first chunk isn't valid base64. The rest must still be treated as part of the transfer.
+	replies, term := runWithReplies(t, "\x1b_Ga=T,f=32,s=1,v=1,i=1,m=1;!!!!\x1b\\"+
+		"\x1b_Gm=1;AAAA\x1b\\"+
+		"\x1b_Gm=0;AAAA\x1b\\")
+	if got, want := replies, "\x1b_Gi=1;EINVAL:invalid base64 payload\x1b\\"; got != want {
+		t.Errorf("replies = %q, want %q", got, want)
+	}
+	if term.kitty.pending != nil || len(term.kitty.images) != 0 {
+		t.Errorf("transfer state wasn't reset, or an image was stored")
+	}
+
+	// A later chunk failing is also reported once, even if the last chunk is valid.
+	replies, _ = runWithReplies(t, "\x1b_Ga=T,f=32,s=1,v=1,i=1,m=1;AAAA\x1b\\"+
+		"\x1b_Gm=1;!!!!\x1b\\"+
+		"\x1b_Gm=0;AAAA\x1b\\")
+	if got, want := replies, "\x1b_Gi=1;EINVAL:invalid base64 payload\x1b\\"; got != want {
+		t.Errorf("replies = %q, want %q", got, want)
+	}
+}
+
+func TestKittyRawImage(t *testing.T) {
+	tests := []struct {
+		name, control, want string
+	}{
+		{"valid", "f=24,s=1,v=1", "OK"},
+		{"insufficient data", "f=32,s=1,v=1", "ENODATA"},
+		{"missing size", "f=24", "EINVAL"},
+		{"negative size", "f=24,s=-1,v=1", "EINVAL"},
+		{"too wide", "f=24,s=4097,v=1", "EFBIG"},
+		{"too tall", "f=24,s=1,v=4097", "EFBIG"},
+		// s*v*4 overflows to a small number.
+		{"overflow", "f=32,s=4611686018427387904,v=4", "EFBIG"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			replies, _ := runWithReplies(t, "\x1b_Ga=T,i=1,"+test.control+";AAAA\x1b\\")
+			if !strings.Contains(replies, test.want) {
+				t.Errorf("reply = %q, want %s", replies, test.want)
+			}
+		})
+	}
+
+	// Without an ID there's no reply, but the terminal must survive.
+	runWithReplies(t, "\x1b_Ga=T,f=32,s=4611686018427387904,v=4;AAAA\x1b\\")
+}
+
+func TestKittyStoredBytes(t *testing.T) {
+	var k kittyState
+	for id := 1; id <= 5; id++ {
+		k.store(id, &kittyImage{size: 100 << 20})
+	}
+	if k.storedBytes > maxKittyStoredBytes {
+		t.Errorf("storedBytes = %d, want at most %d", k.storedBytes, maxKittyStoredBytes)
+	}
+	if got, want := k.order, []int{4, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("stored images = %v, want the newest ones, %v", got, want)
+	}
+
+	k.store(5, &kittyImage{size: 1 << 20})
+	if got, want := k.storedBytes, 101<<20; got != want {
+		t.Errorf("storedBytes = %d after replacing an image, want %d", got, want)
+	}
+}
+
+func TestKittyPlacements(t *testing.T) {
+	const place = "\x1b_Ga=T,f=24,s=1,v=1,i=1,p=1,q=2;AAAA\x1b\\"
+	_, term := runWithReplies(t, place)
+	if got := len(term.screen.kittyPlacements); got != 1 {
+		t.Fatalf("%d placements, want 1", got)
+	}
+
+	// Once the line is finished, its placements are history and can't be deleted.
+	feed(term, "\n\x1b_Ga=d,d=i,i=1\x1b\\")
+	if term.screen.kittyPlacements != nil {
+		t.Errorf("placements = %v after a newline, want none", term.screen.kittyPlacements)
+	}
+	if l := term.Snapshot().Lines()[0]; !strings.Contains(l, "<img") {
+		t.Errorf("finished line = %q, want it to keep the image", l)
+	}
+
+	feed(term, place+"\x1b_Ga=d,d=i,i=1\x1b\\")
+	if l := term.Snapshot().Lines()[1]; strings.Contains(l, "<img") {
+		t.Errorf("active line = %q, want the image deleted", l)
+	}
+}
@@ -0,0 +1,51 @@
+package terminal
+
+import "strings"
+
+// line is a finalised scrollback line. Only the active line is stored cell-by-cell (as []node); once newline moves
+// it into the scrollback, it's compacted into its text plus a run-length list of attribute spans, which is much
+// smaller than a rune and a pointer per character and gives the GC far fewer pointers to chase.
+type line struct {
+	text  string
+	spans []span
+
+	rev uint64 // revision at which the line was finalised
+
+	// When the line was first written and last modified, in Unix nanoseconds, based on when output arrived.
+	created, modified int64
+
+	// How many times the line was redrawn in place, and the last few intermediate frames. See progress.go.
+	redraws int
+	frames  []line
+
+	palette *palette   // the palette when the line was finalised, which inline styles are rendered with
+	paths   []pathLink // paths to existing files in the text, found when the line was finalised. See cwd.go.
+}
+
+// span is a run of text with the same attributes. Spans are ordered and cover the whole line.
+type span struct {
+	end int // byte offset into line.text
+	*styleAttributes
+}
+
+func compactLine(nodes []node) line {
+	var text strings.Builder
+	var spans []span
+	for _, n := range nodes {
+		if len(spans) == 0 || !spans[len(spans)-1].styleAttributes.Equals(n.styleAttributes) {
+			spans = append(spans, span{styleAttributes: n.styleAttributes})
+		}
+		text.WriteRune(n.rune)
+		spans[len(spans)-1].end = text.Len()
+	}
+	return line{text: text.String(), spans: spans}
+}
+
+// forEachSpan calls f with the text and attributes of each span in order.
+func (l line) forEachSpan(f func(text string, attrs *styleAttributes)) {
+	start := 0
+	for _, sp := range l.spans {
+		f(l.text[start:sp.end], sp.styleAttributes)
+		start = sp.end
+	}
+}
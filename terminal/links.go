@@ -0,0 +1,79 @@
+package terminal
+
+import (
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LinkPolicy decides which OSC 8 hyperlinks are rendered as links. Programs choose their own link targets, so without
+// a policy a command could emit a javascript: or data: link that runs in the viewer's browser. Links that aren't
+// allowed are rendered as plain text.
+//
+// The zero value allows http, https, and file links to this host.
+type LinkPolicy struct {
+	// Schemes that are allowed, in lowercase. Defaults to http, https and file.
+	Schemes []string
+	// FileHosts are the hosts allowed in file: links, in addition to this host (an empty host, "localhost" or the
+	// hostname). The OSC 8 spec asks terminals to check this, because a path is meaningless on another machine.
+	FileHosts []string
+	// Rewrite, if set, is called with every allowed link and returns the href to render, or "" to render plain text.
+	// It can be used to e.g. proxy file: links through the web server.
+	Rewrite func(*url.URL) string
+}
+
+var defaultLinkSchemes = []string{"http", "https", "file"}
+
+var localHostname, _ = os.Hostname()
+
+// href returns the sanitized href for uri, or "" if the link isn't allowed.
+func (p *LinkPolicy) href(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme == "" {
+		return ""
+	}
+
+	schemes := p.Schemes
+	if schemes == nil {
+		schemes = defaultLinkSchemes
+	}
+	if !containsString(schemes, u.Scheme) {
+		return ""
+	}
+	if u.Scheme == "file" && !p.allowFileHost(u.Hostname()) {
+		return ""
+	}
+
+	if p.Rewrite != nil {
+		return p.Rewrite(u)
+	}
+	return u.String()
+}
+
+func (p *LinkPolicy) allowFileHost(host string) bool {
+	return isLocalHost(host) || containsString(p.FileHosts, strings.ToLower(host))
+}
+
+// isLocalHost reports whether host in a file: URI refers to this host.
+func isLocalHost(host string) bool {
+	return host == "" || strings.EqualFold(host, "localhost") || strings.EqualFold(host, localHostname)
+}
+
+func containsString(ss []string, s string) bool {
+	for _, s2 := range ss {
+		if s == s2 {
+			return true
+		}
+	}
+	return false
+}
+
+// groupID identifies all the parts of a link, so the viewer can highlight them together. Parts of a link also share
+// a URI, but it isn't included here because the viewer can compare hrefs.
+func (l hyperlink) groupID() string {
+	if l.id != "" {
+		return "id:" + l.id
+	}
+	return "seq:" + strconv.Itoa(l.seq)
+}
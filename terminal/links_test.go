@@ -0,0 +1,81 @@
+package terminal
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestLinkPolicy(t *testing.T) {
+	proxy := func(u *url.URL) string {
+		if u.Scheme != "file" {
+			return u.String()
+		}
+		return "/files" + u.Path
+	}
+	tests := []struct {
+		name   string
+		policy LinkPolicy
+		uri    string
+		want   string
+	}{
+		{"http", LinkPolicy{}, "http://example.com/a", "http://example.com/a"},
+		{"https", LinkPolicy{}, "https://example.com/a?b=c", "https://example.com/a?b=c"},
+		{"javascript", LinkPolicy{}, "javascript:alert(1)", ""},
+		{"mixed-case javascript", LinkPolicy{}, "JavaScript:alert(1)", ""},
+		{"javascript with leading space", LinkPolicy{}, " javascript:alert(1)", ""},
+		{"data", LinkPolicy{}, "data:text/html,<script>alert(1)</script>", ""},
+		{"relative", LinkPolicy{}, "/etc/passwd", ""},
+		{"scheme-less", LinkPolicy{}, "//example.com/a", ""},
+		{"empty", LinkPolicy{}, "", ""},
+		{"local file", LinkPolicy{}, "file:///etc/hosts", "file:///etc/hosts"},
+		{"localhost file", LinkPolicy{}, "file://localhost/etc/hosts", "file://localhost/etc/hosts"},
+		{"file on another host", LinkPolicy{}, "file://otherhost/etc/hosts", ""},
+		{"file on an allowed host", LinkPolicy{FileHosts: []string{"otherhost"}}, "file://OtherHost/etc/hosts",
+			"file://OtherHost/etc/hosts"},
+		{"custom scheme", LinkPolicy{Schemes: []string{"vscode"}}, "vscode://file/a.go:1", "vscode://file/a.go:1"},
+		{"default scheme not in Schemes", LinkPolicy{Schemes: []string{"vscode"}}, "https://example.com", ""},
+		{"rewrite", LinkPolicy{Rewrite: proxy}, "file:///etc/hosts", "/files/etc/hosts"},
+		{"rewrite to nothing", LinkPolicy{Rewrite: func(*url.URL) string { return "" }}, "https://example.com", ""},
+		{"rewrite isn't called for disallowed links", LinkPolicy{Rewrite: proxy}, "javascript:alert(1)", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.policy.href(test.uri); got != test.want {
+				t.Errorf("href(%q) = %q, want %q", test.uri, got, test.want)
+			}
+		})
+	}
+}
+
+func TestRenderLink(t *testing.T) {
+	tests := []struct {
+		name, input string
+		want        []string // substrings of the rendered line
+		notWant     []string
+	}{
+		{"attributes", "\x1b]8;id=a\"b<;https://example.com/?a=1&b=2\x1b\\link\x1b]8;;\x1b\\",
+			[]string{`<a href="https://example.com/?a=1&amp;b=2" rel="noopener noreferrer" data-link-id="id:a&#34;b&lt;">link</a>`},
+			nil},
+		{"quotes in the URI", "\x1b]8;;https://example.com/\"onmouseover=\"x\x1b\\link\x1b]8;;\x1b\\",
+			[]string{`rel="noopener noreferrer"`}, []string{`"onmouseover`}},
+		{"disallowed", "\x1b]8;;javascript:alert(1)\x1b\\link\x1b]8;;\x1b\\", []string{"link"}, []string{"<a", "javascript"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			term := newTestTerminal(nil)
+			feed(term, test.input)
+			got := term.Snapshot().Lines()[0]
+			for _, want := range test.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("rendered %q, want it to contain %q", got, want)
+				}
+			}
+			for _, notWant := range test.notWant {
+				if strings.Contains(got, notWant) {
+					t.Errorf("rendered %q, don't want it to contain %q", got, notWant)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package terminal
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Applications ask for the user's attention with the bell, or with desktop notifications from one of several
+// protocols: OSC 9;<body> (iTerm2), OSC 777;notify;<title>;<body> (urxvt, foot) and OSC 99 (kitty). They're all
+// passed on as events, so the viewer can e.g. show a browser notification when a long build finishes.
+
+// Bell is emitted when the application rings the bell (BEL).
+type Bell struct{}
+
+func (Bell) EventType() string { return "bell" }
+
+// bellSuppressTime is how long further bells are ignored after one is emitted, like xterm's resource of the same name,
+// so that a program printing BEL in a loop can't push every other event out of the recent events.
+const bellSuppressTime = 200 * time.Millisecond
+
+func (s *screen) ringBell() {
+	if since := s.now - s.lastBell; s.lastBell != 0 && since >= 0 && since < int64(bellSuppressTime) {
+		return
+	}
+	s.lastBell = s.now
+	s.emit(Bell{})
+}
+
+// Notification is emitted when the application asks for a desktop notification.
+type Notification struct {
+	ID    string `json:"id,omitempty"` // only set by OSC 99, which can build up a notification over several sequences
+	Title string `json:"title,omitempty"`
+	Body  string `json:"body"`
+}
+
+func (Notification) EventType() string { return "notification" }
+
+// maxPendingNotifications limits how many OSC 99 notifications can be in progress at once.
+const maxPendingNotifications = 16
+
+// handleITerm2Notification handles the params of OSC 9 after the "9". ConEmu also uses OSC 9, with a numeric
+// subcommand; of those, only 9;2 (message box) is a notification.
+func (t *RichTextTerminal) handleITerm2Notification(params []string) {
+	if len(params) == 0 {
+		return
+	}
+	if _, err := strconv.Atoi(params[0]); err == nil && len(params) > 1 {
+		if params[0] == "2" {
+			t.screen.emit(Notification{Body: strings.Join(params[1:], ";")})
+		}
+		return
+	}
+	t.screen.emit(Notification{Body: strings.Join(params, ";")})
+}
+
+// handleKittyNotification handles the params of OSC 99 after the "99": colon-separated key=value metadata, and the
+// payload, which is the title or the body depending on the metadata.
+// See https://sw.kovidgoyal.net/kitty/desktop-notifications/
+func (t *RichTextTerminal) handleKittyNotification(params []string) {
+	if len(params) < 2 {
+		return
+	}
+	metadata := map[string]string{}
+	for _, kv := range strings.Split(params[0], ":") {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			metadata[k] = v
+		}
+	}
+	payload := strings.Join(params[1:], ";")
+	if metadata["e"] == "1" {
+		decoded, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return
+		}
+		payload = string(decoded)
+	}
+
+	id := metadata["i"]
+	if !validNotificationID(id) { // it's echoed back in replies
+		return
+	}
+	s := &t.screen
+	n := s.pendingNotifications[id]
+	if n == nil {
+		if len(s.pendingNotifications) >= maxPendingNotifications {
+			s.pendingNotifications = nil // the application is probably not finishing them
+		}
+		if s.pendingNotifications == nil {
+			s.pendingNotifications = map[string]*Notification{}
+		}
+		n = &Notification{ID: id}
+		s.pendingNotifications[id] = n
+	}
+
+	switch metadata["p"] {
+	case "", "title":
+		n.Title += payload
+	case "body":
+		n.Body += payload
+	case "?": // query which features we support
+		delete(s.pendingNotifications, id)
+		t.queueReply("\x1b]99;i=%s:p=?;p=title,body,?\x1b\\", id)
+		return
+	}
+
+	if metadata["d"] != "0" { // done, unless more is to come
+		delete(s.pendingNotifications, id)
+		s.emit(*n)
+	}
+}
+
+// validNotificationID reports whether id only has the characters the protocol allows in OSC 99 IDs.
+func validNotificationID(id string) bool {
+	for _, c := range id {
+		ok := c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("_+.-", c)
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBellSuppressTime(t *testing.T) {
+	term := newTestTerminal(nil)
+	bells := func() int {
+		n := 0
+		for _, ev := range term.Snapshot().events {
+			if _, ok := ev.Event.(Bell); ok {
+				n++
+			}
+		}
+		return n
+	}
+	ring := func(at time.Duration, input string) {
+		term.screen.now = time.Unix(1, 0).Add(at).UnixNano()
+		term.parser = newParser(strings.NewReader(input), term)
+		for term.parser.Continue() == nil {
+		}
+	}
+
+	ring(0, strings.Repeat("\a", 1000))
+	if got := bells(); got != 1 {
+		t.Errorf("%d bells after a flood, want 1", got)
+	}
+	ring(100*time.Millisecond, "\a")
+	if got := bells(); got != 1 {
+		t.Errorf("%d bells after another within %v, want 1", got, bellSuppressTime)
+	}
+	ring(300*time.Millisecond, "\a")
+	if got := bells(); got != 2 {
+		t.Errorf("%d bells after another after %v, want 2", got, bellSuppressTime)
+	}
+}
+
+func TestKittyNotificationQuery(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{"query", "\x1b]99;i=a-1.b_c+d:p=?;\x1b\\", "\x1b]99;i=a-1.b_c+d:p=?;p=title,body,?\x1b\\"},
+		{"id with a space", "\x1b]99;i=a b:p=?;\x1b\\", ""},
+		{"id with a slash", "\x1b]99;i=a/b:p=?;\x1b\\", ""},
+		{"id with a control character", "\x1b]99;i=a\x7fb:p=?;\x1b\\", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if replies, _ := runWithReplies(t, test.input); replies != test.want {
+				t.Errorf("replies = %q, want %q", replies, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package terminal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// palette holds the terminal's dynamic colors: the 256 indexed colors (OSC 4) and the default foreground,
+// background and cursor colors (OSC 10/11/12). A nil color means the built-in default, which for the default
+// foreground and background is whatever the page's stylesheet says.
+//
+// Like styleAttributes, a palette is never modified in place once it's in use, so snapshots can share it.
+type palette struct {
+	colors         [256]Color
+	fg, bg, cursor Color
+}
+
+func newPalette(theme *Theme) *palette {
+	p := &palette{}
+	if theme == nil {
+		return p
+	}
+	for i, spec := range theme.Palette {
+		if c, ok := parseXColor(spec); ok {
+			p.colors[i] = c
+		}
+	}
+	p.fg, _ = parseColorOrNil(theme.Foreground)
+	p.bg, _ = parseColorOrNil(theme.Background)
+	p.cursor, _ = parseColorOrNil(theme.Cursor)
+	return p
+}
+
+func parseColorOrNil(spec string) (Color, bool) {
+	if c, ok := parseXColor(spec); ok {
+		return c, true
+	}
+	return nil, false
+}
+
+// rgb returns the RGB value of an indexed color, for answering queries.
+func (p *palette) rgb(c ANSIColor) RGBColor {
+	if rgb, ok := p.colors[c].(RGBColor); ok {
+		return rgb
+	}
+	return defaultRGB[c]
+}
+
+// parseXColor parses the color specs that xterm accepts in OSC 4/10/11/12 (see XParseColor), except for color names:
+// rgb:<r>/<g>/<b> with 1-4 hex digits per component, and #rgb with 1-4 hex digits per component. rgb: components are
+// scaled, so "f" means 0xff, but # components are the most significant bits, so #f80 means #f00080000000.
+func parseXColor(spec string) (RGBColor, bool) {
+	var components []string
+	scaled := true
+	switch {
+	case strings.HasPrefix(spec, "rgb:"):
+		components = strings.Split(spec[len("rgb:"):], "/")
+		if len(components) != 3 {
+			return RGBColor{}, false
+		}
+	case strings.HasPrefix(spec, "#"):
+		hex := spec[1:]
+		if len(hex) == 0 || len(hex)%3 != 0 || len(hex) > 12 {
+			return RGBColor{}, false
+		}
+		n := len(hex) / 3
+		components = []string{hex[:n], hex[n : 2*n], hex[2*n:]}
+		scaled = false
+	default:
+		return RGBColor{}, false
+	}
+
+	var rgb [3]uint8
+	for i, component := range components {
+		if len(component) == 0 || len(component) > 4 {
+			return RGBColor{}, false
+		}
+		v, err := strconv.ParseUint(component, 16, 16)
+		if err != nil {
+			return RGBColor{}, false
+		}
+		if !scaled {
+			rgb[i] = uint8(v << (4 * (4 - len(component))) >> 8)
+			continue
+		}
+		// Scale to 8 bits, e.g. "f" means 0xff and "fff0" means 0xff.
+		maxValue := uint64(1)<<(4*len(component)) - 1
+		rgb[i] = uint8((v*0xff + maxValue/2) / maxValue)
+	}
+	return RGBColor{rgb[0], rgb[1], rgb[2]}, true
+}
+
+// xColorSpec formats c the way xterm answers color queries.
+func (c RGBColor) xColorSpec() string {
+	return fmt.Sprintf("rgb:%02x%02x/%02x%02x/%02x%02x", c.r, c.r, c.g, c.g, c.b, c.b)
+}
+
+// The screen's current palette lives in its renderer. Each line keeps the palette it was finalised with (see
+// renderLine), so in inline mode palette changes only affect lines written afterwards.
+
+func (s *screen) updatePalette(update func(p *palette)) {
+	cpy := *s.renderer.palette
+	update(&cpy)
+	s.renderer.palette = &cpy
+	s.rev++
+	s.paletteRev = s.rev
+}
+
+func (s *screen) setPaletteColor(c ANSIColor, color Color) {
+	s.updatePalette(func(p *palette) { p.colors[c] = color })
+}
+
+func (s *screen) resetPaletteColor(c ANSIColor) {
+	s.updatePalette(func(p *palette) { p.colors[c] = s.basePalette.colors[c] })
+}
+
+func (s *screen) resetPalette() {
+	s.updatePalette(func(p *palette) { p.colors = s.basePalette.colors })
+}
+
+// dynamicColor returns a pointer to the color set by OSC 10+n, i.e. the default foreground, background or cursor.
+func (p *palette) dynamicColor(n int) *Color {
+	switch n {
+	case 0:
+		return &p.fg
+	case 1:
+		return &p.bg
+	case 2:
+		return &p.cursor
+	}
+	return nil
+}
+
+func (s *screen) setDynamicColor(n int, color Color) {
+	s.updatePalette(func(p *palette) { *p.dynamicColor(n) = color })
+}
+
+func (s *screen) resetDynamicColor(n int) {
+	s.updatePalette(func(p *palette) { *p.dynamicColor(n) = *s.basePalette.dynamicColor(n) })
+}
+
+// dynamicColorRGB returns the RGB value of the color set by OSC 10+n, for answering queries.
+func (s *screen) dynamicColorRGB(n int) RGBColor {
+	return s.renderer.palette.dynamicRGB(n)
+}
+
+func (p *palette) dynamicRGB(n int) RGBColor {
+	switch c := (*p.dynamicColor(n)).(type) {
+	case RGBColor:
+		return c
+	case ANSIColor:
+		return p.rgb(c)
+	}
+	if n == 1 {
+		return RGBColor{0xff, 0xff, 0xff} // matches the defaults in render.go
+	}
+	return RGBColor{0x00, 0x00, 0x00}
+}
+
+// resolve returns the RGB value of a cell's color. A nil color is the default foreground or background.
+func (p *palette) resolve(c Color, fg bool) RGBColor {
+	switch c := c.(type) {
+	case RGBColor:
+		return c
+	case ANSIColor:
+		return p.rgb(c)
+	}
+	if fg {
+		return p.dynamicRGB(0)
+	}
+	return p.dynamicRGB(1)
+}
@@ -0,0 +1,57 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestPaletteAtWriteTime checks that in inline mode, lines keep the colors they were written with.
+func TestPaletteAtWriteTime(t *testing.T) {
+	term := newTestTerminal(strings.NewReader("\x1b[31mbefore\x1b[m\n\x1b]4;1;#00ff00\x1b\\\x1b[31mafter\x1b[m\n"))
+	for term.parser.Continue() == nil {
+	}
+
+	lines := term.Snapshot().Lines()
+	before, after := lines[0], lines[1]
+	if strings.Contains(before, "#00ff00") {
+		t.Errorf("line written before the palette change = %q, want the original red", before)
+	}
+	if !strings.Contains(after, "#00ff00") {
+		t.Errorf("line written after the palette change = %q, want it to contain #00ff00", after)
+	}
+}
+
+func TestParseXColor(t *testing.T) {
+	tests := []struct {
+		spec string
+		want RGBColor
+		ok   bool
+	}{
+		{"rgb:ff/80/00", RGBColor{0xff, 0x80, 0x00}, true},
+		{"rgb:f/8/0", RGBColor{0xff, 0x88, 0x00}, true},
+		{"rgb:ffff/8080/0000", RGBColor{0xff, 0x80, 0x00}, true},
+		{"rgb:fff/800/000", RGBColor{0xff, 0x80, 0x00}, true},
+		{"rgb:FF/80/00", RGBColor{0xff, 0x80, 0x00}, true},
+		{"#f80", RGBColor{0xf0, 0x80, 0x00}, true},
+		{"#3a7", RGBColor{0x30, 0xa0, 0x70}, true},
+		{"#fff888000", RGBColor{0xff, 0x88, 0x00}, true},
+		{"#ff8000", RGBColor{0xff, 0x80, 0x00}, true},
+		{"#ffff80800000", RGBColor{0xff, 0x80, 0x00}, true},
+		{"rgb:ff/80", RGBColor{}, false},
+		{"rgb:ff/80/00/00", RGBColor{}, false},
+		{"rgb:ff//00", RGBColor{}, false},
+		{"rgb:fffff/0/0", RGBColor{}, false},
+		{"rgb:gg/00/00", RGBColor{}, false},
+		{"#", RGBColor{}, false},
+		{"#ff80", RGBColor{}, false},
+		{"#fffff0000000000", RGBColor{}, false},
+		{"red", RGBColor{}, false},
+		{"?", RGBColor{}, false},
+	}
+	for _, test := range tests {
+		got, ok := parseXColor(test.spec)
+		if got != test.want || ok != test.ok {
+			t.Errorf("parseXColor(%q) = %v, %v, want %v, %v", test.spec, got, ok, test.want, test.ok)
+		}
+	}
+}
@@ -34,6 +34,12 @@ type dispatchHandler interface {
 	handleOSC(params []string)
 	handleCSI(params []string, intermediates string, final byte)
 	handleDSC(params []string, intermediates string, final byte)
+	// putDCS receives the data string of the DCS sequence last passed to handleDSC, in chunks. data is only valid
+	// for the duration of the call.
+	putDCS(data []byte)
+	// unhookDCS is called when the DCS sequence ends.
+	unhookDCS()
+	handleAPC(data string)
 }
 
 type state func(p *parser) (state, error)
@@ -74,6 +80,8 @@ type parser struct {
 	partialParam         strings.Builder
 	partialParams        []string
 	partialIntermediates strings.Builder
+
+	dcsData []byte
 }
 
 func newParser(src io.Reader, handler dispatchHandler) *parser {
@@ -213,8 +221,10 @@ func parseEscape(p *parser) (state, error) {
 		return parseCSIEntry, nil
 	case low == ']':
 		return parseOSCString, nil
-	// SOS, PM, APC
-	case low == 'X' || low == '^' || low == '_':
+	case low == '_':
+		return parseAPCString, nil
+	// SOS, PM
+	case low == 'X' || low == '^':
 		return parseIgnoreAll, nil
 	// String Terminator (ST) is a no-op, no need to dispatch
 	case low == '\\':
@@ -286,6 +296,33 @@ func parseOSCString(p *parser) (state, error) {
 	}
 }
 
+// parseAPCString collects an Application Program Command, which unlike OSC isn't split into params. The kitty graphics
+// protocol uses APC, and like OSC files, its images are collected in full (though they're sent in chunks).
+func parseAPCString(p *parser) (state, error) {
+	p.clear()
+	for {
+		c, err := p.buf.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case graphicalCode(c):
+			p.partialParam.WriteByte(c)
+		case c == ascii.ESC:
+			// includes ST
+			p.handleAPC(p.partialParam.String())
+			return parseEscape, parserPaused
+		case terminatingCtrlCode(c):
+			p.handleAPC(p.partialParam.String())
+			_ = p.buf.UnreadByte()
+			return parseOutput, parserPaused
+		default:
+			// ignore
+		}
+	}
+}
+
 func parseCSIEntry(p *parser) (state, error) {
 	p.clear()
 
@@ -300,14 +337,13 @@ func parseCSIEntry(p *parser) (state, error) {
 	case c >= 0x20 && c <= 0x2f:
 		p.collectIntermediate(c)
 		return parseCSIIntermediate, nil
-	case c >= '0' && c <= '9' || c == ';':
+	// NOTE: Unlike the DEC parser, we collect ':' to support SGR subparameters, e.g. 4:3 (curly underline).
+	case c >= '0' && c <= '9' || c == ';' || c == ':':
 		p.collectParam(c)
 		return parseCSIParam, nil
 	case c >= 0x3c && c <= 0x3f:
 		p.collectIntermediate(c)
 		return parseCSIParam, nil
-	case c == 0x3a:
-		return parseCSIIgnore, nil
 	case c >= 0x40 && c <= 0x7e:
 		p.handleCSI([]string{""}, "", c)
 		return parseOutput, parserPaused
@@ -330,9 +366,9 @@ func parseCSIParam(p *parser) (state, error) {
 		case c >= 0x20 && c <= 0x2f:
 			p.collectIntermediate(c)
 			return parseCSIIntermediate, nil
-		case c >= '0' && c <= '9' || c == ';':
+		case c >= '0' && c <= '9' || c == ';' || c == ':':
 			p.collectParam(c)
-		case c == 0x3a || c >= 0x3c && c <= 0x3f:
+		case c >= 0x3c && c <= 0x3f:
 			return parseCSIIgnore, nil
 		case c >= 0x40 && c <= 0x7e:
 			p.handleCSI(p.params(), p.intermediates(), c)
@@ -481,6 +517,11 @@ func parseDCSParam(p *parser) (state, error) {
 	}
 }
 
+// dcsChunkSize is how much of a DCS data string is buffered before it's passed to putDCS.
+const dcsChunkSize = 4096
+
+// parseDCSPassthrough implements the "hook", "put" and "unhook" actions. Data strings can be large (e.g. sixel
+// images), so they're streamed to the dispatchHandler in chunks instead of being collected like params.
 func parseDCSPassthrough(p *parser) (state, error) {
 	c, err := p.buf.ReadByte()
 	if err != nil {
@@ -488,9 +529,40 @@ func parseDCSPassthrough(p *parser) (state, error) {
 	}
 	p.handleDSC(p.params(), p.intermediates(), c)
 
-	// TODO: stream data after final byte to dispatchHandler
-	log.Print("DCS parser ignores data trailing final byte")
-	return parseIgnoreAll, nil
+	p.dcsData = p.dcsData[:0]
+	flush := func() {
+		if len(p.dcsData) > 0 {
+			p.putDCS(p.dcsData)
+			p.dcsData = p.dcsData[:0]
+		}
+	}
+	for {
+		c, err := p.buf.ReadByte()
+		if err != nil {
+			flush()
+			return nil, err
+		}
+
+		switch {
+		case c == ascii.DEL:
+			// ignore
+		case c == ascii.ESC:
+			// includes ST
+			flush()
+			p.unhookDCS()
+			return parseEscape, parserPaused
+		case terminatingCtrlCode(c):
+			flush()
+			p.unhookDCS()
+			_ = p.buf.UnreadByte()
+			return parseOutput, parserPaused
+		default:
+			p.dcsData = append(p.dcsData, c)
+			if len(p.dcsData) >= dcsChunkSize {
+				flush()
+			}
+		}
+	}
 }
 
 func parseIgnoreAll(p *parser) (state, error) {
@@ -0,0 +1,54 @@
+package terminal
+
+// Progress bars and spinners redraw the active line in place, by moving the cursor back (usually with a carriage
+// return) and writing over it, sometimes thousands of times. Only the final state of the line ever reaches the
+// scrollback, but we count the redraws so renderers can tell that a line was a progress indicator, and optionally
+// keep the last few intermediate frames for playback.
+
+// minProgressRedraws is how many times a line must be redrawn to count as a progress indicator. Fewer redraws are
+// usually corrections, like a backspace at a readline prompt or a shell redrawing its prompt.
+const minProgressRedraws = 3
+
+// progress reports whether the line looks like a progress indicator.
+func (l line) progress() bool {
+	return l.redraws >= minProgressRedraws
+}
+
+// markRedraw notes that the cursor moved back over the active line, so the next change to it is a redraw.
+func (s *screen) markRedraw() {
+	if len(s.activeLine) > 0 {
+		s.redrawing = true
+	}
+}
+
+// recordFrame is called when a redraw begins, before the active line is changed.
+func (s *screen) recordFrame() {
+	s.redrawing = false
+	s.activeRedraws++
+	if s.frameLimit <= 0 {
+		return
+	}
+
+	frame := compactLine(s.activeLine)
+	frame.rev, frame.created, frame.modified = s.activeRev, s.activeCreated, s.activeModified
+	if len(s.activeFrames) >= s.frameLimit {
+		// Never modify frames in place: snapshots may share the backing array.
+		s.activeFrames = s.activeFrames[len(s.activeFrames)-s.frameLimit+1:]
+	}
+	s.activeFrames = append(s.activeFrames, frame)
+}
+
+// Frames renders the intermediate frames kept for the line with the given ID, oldest first, not including its
+// current state. It returns nil if the line isn't in memory or wasn't redrawn. Frames are only kept when the
+// terminal was created with WithProgressFrames.
+func (sn Snapshot) Frames(id int) []LineChange {
+	l, ok := sn.line(id)
+	if !ok {
+		return nil
+	}
+	ret := make([]LineChange, 0, len(l.frames))
+	for _, frame := range l.frames {
+		ret = append(ret, newLineChange(sn.renderer, id, frame))
+	}
+	return ret
+}
@@ -0,0 +1,27 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestProgress(t *testing.T) {
+	for _, tt := range []struct {
+		output   string
+		progress bool
+	}{
+		{"plain\n", false},
+		{"abc\bd\n", false},          // a correction
+		{"ab\x1b7cd\x1b8X\n", false}, // a redraw after restoring the cursor
+		{"\r10%\r20%\r30%\r40%\n", true},
+		{"|\b/\b-\b\\\b|\n", true},
+	} {
+		term := newTestTerminal(strings.NewReader(tt.output))
+		for term.parser.Continue() == nil {
+		}
+		lines := term.ChangesSince(0).Lines
+		if got := lines[0].Progress; got != tt.progress {
+			t.Errorf("%q: Progress = %v, want %v", tt.output, got, tt.progress)
+		}
+	}
+}
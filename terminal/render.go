@@ -6,66 +6,293 @@ import (
 	"strings"
 )
 
-func renderLine(line []node) string {
+// Default colors, blink animations and fonts are left to the page's stylesheet, through these CSS variables and
+// keyframes:
+//
+//	--term-fg, --term-bg     the default foreground and background colors (needed to invert default colors)
+//	--term-font-fraktur      the font for SGR 20 (Fraktur)
+//	--term-font-1 ... 9      the alternative fonts for SGR 11-19
+//	@keyframes term-blink    used by both blink speeds
+const (
+	defaultFgCSS = "var(--term-fg, black)"
+	defaultBgCSS = "var(--term-bg, white)"
+)
+
+// htmlRenderer renders lines as HTML, either with inline styles (the default), or with CSS classes that are styled
+// by a theme's stylesheet (see Theme.WriteStylesheet).
+type htmlRenderer struct {
+	classes bool
+	links   LinkPolicy
+	palette *palette
+	// minContrast is the minimum WCAG contrast ratio between the foreground and background of styled text, or 0.
+	minContrast float64
+}
+
+// colorCSS returns the CSS color for c, taking palette changes into account. In class mode, palette changes are
+// instead applied by the viewer's stylesheet (see ColorChanges), so that they also affect lines it already has.
+func (r htmlRenderer) colorCSS(c Color) string {
+	if ac, ok := c.(ANSIColor); ok && r.palette.colors[ac] != nil {
+		return r.palette.colors[ac].HTMLColorCode()
+	}
+	return c.HTMLColorCode()
+}
+
+func (r htmlRenderer) renderLine(l line) string {
+	// Inline styles can't be updated once a client has the line, so render with the colors the line was written with,
+	// whenever it's rendered. In class mode, the viewer's stylesheet applies the current palette to every line.
+	if l.palette != nil && !r.classes {
+		r.palette = l.palette
+	}
+
 	var raw strings.Builder
 
+	var href string
 	openTags := func(attr *styleAttributes) {
-		if attr.uri != "" {
-			fmt.Fprintf(&raw, "<a href=\"%s\">", html.EscapeString(attr.uri))
+		if href = r.links.href(attr.link.uri); href != "" {
+			fmt.Fprintf(&raw, "<a href=\"%s\" rel=\"noopener noreferrer\" data-link-id=\"%s\">",
+				html.EscapeString(href), html.EscapeString(attr.link.groupID()))
 		}
-		if !attr.Empty() {
+		if attr.Unstyled() {
+			return
+		}
+		if r.classes {
+			raw.WriteString("<span")
+			r.writeClasses(&raw, attr)
+			raw.WriteString(">")
+		} else {
 			raw.WriteString("<span style=\"")
-			if attr.hasStyle(Bold) {
-				raw.WriteString("font-weight:bold;")
-			}
-			if attr.hasStyle(Italic) {
-				raw.WriteString("font-style:italic;")
-			}
-			if attr.hasStyle(Underline) {
-				raw.WriteString("text-decoration:underline;")
-			}
-			if attr.hasStyle(Hidden) {
-				raw.WriteString("visibility:hidden;")
-			}
-			if attr.fg != nil {
-				fmt.Fprintf(&raw, "color:%s;", attr.fg.HTMLColorCode())
-			}
-			if attr.bg != nil {
-				fmt.Fprintf(&raw, "background-color:%s;", attr.bg.HTMLColorCode())
-			}
+			r.writeStyle(&raw, attr)
 			raw.WriteString("\">")
 		}
 	}
 	closeTags := func(attr *styleAttributes) {
-		if !attr.Empty() {
+		if !attr.Unstyled() {
 			raw.WriteString("</span>")
 		}
-		if attr.uri != "" {
+		if href != "" {
 			fmt.Fprintf(&raw, "</a>")
 		}
 	}
 
-	prevAttr := &styleAttributes{}
-	for _, n := range line {
-		if !n.styleAttributes.Equals(prevAttr) {
-			closeTags(prevAttr)
-			openTags(n.styleAttributes)
-			prevAttr = n.styleAttributes
+	offset := 0
+	l.forEachSpan(func(text string, attrs *styleAttributes) {
+		openTags(attrs)
+		if attrs.image != nil {
+			writeImages(&raw, text, attrs.image)
+		} else if len(l.paths) > 0 && href == "" {
+			r.writePathLinks(&raw, text, offset, l.paths)
+		} else {
+			raw.WriteString(html.EscapeString(text))
 		}
+		closeTags(attrs)
+		offset += len(text)
+	})
+
+	return raw.String()
+}
 
-		//raw.WriteRune(n.rune)
-		raw.WriteString(html.EscapeString(string(n.rune)))
+// contrastFg returns a replacement foreground color for attr if its effective foreground and background colors don't
+// have enough contrast. Dim text is checked before it's dimmed, since it's meant to have less contrast.
+func (r htmlRenderer) contrastFg(attr *styleAttributes) (RGBColor, bool) {
+	if r.minContrast <= 0 || attr.hasStyle(Hidden) {
+		return RGBColor{}, false
 	}
-	closeTags(prevAttr)
 
-	return raw.String()
+	fg := r.palette.resolve(attr.fg, true)
+	bg := r.palette.resolve(attr.bg, false)
+	if attr.hasStyle(Inverted) {
+		fg, bg = bg, fg
+	}
+	if ContrastRatio(fg, bg) >= r.minContrast {
+		return RGBColor{}, false
+	}
+	return fg.WithContrast(bg, r.minContrast), true
 }
 
-func (s *screen) Lines() []string {
-	// TODO: obvious race condition
-	ret := make([]string, 0, len(s.scrollback)+1)
-	for _, line := range append(s.scrollback, s.activeLine) {
-		ret = append(ret, renderLine(line))
+// writeStyle writes the inline CSS for attr.
+func (r htmlRenderer) writeStyle(raw *strings.Builder, attr *styleAttributes) {
+	if attr.hasStyle(Bold) {
+		raw.WriteString("font-weight:bold;")
+	}
+	if attr.hasStyle(Italic) {
+		raw.WriteString("font-style:italic;")
+	}
+	if attr.hasStyle(Hidden) {
+		raw.WriteString("visibility:hidden;")
+	}
+
+	// Inverting a default color needs the page's default colors, so only write colors that aren't the default.
+	var fg, bg string
+	if attr.fg != nil {
+		fg = r.colorCSS(attr.fg)
+	}
+	if attr.bg != nil {
+		bg = r.colorCSS(attr.bg)
+	}
+	if attr.hasStyle(Inverted) {
+		fg, bg = bg, fg
+		if fg == "" {
+			fg = defaultBgCSS
+		}
+		if bg == "" {
+			bg = defaultFgCSS
+		}
+	}
+	if adjusted, ok := r.contrastFg(attr); ok {
+		fg = adjusted.HTMLColorCode()
+	}
+	if attr.hasStyle(Dim) {
+		if fg == "" {
+			fg = defaultFgCSS
+		}
+		fg = fmt.Sprintf("color-mix(in srgb,%s 50%%,transparent)", fg)
+	}
+	if fg != "" {
+		fmt.Fprintf(raw, "color:%s;", fg)
+	}
+	if bg != "" {
+		fmt.Fprintf(raw, "background-color:%s;", bg)
+	}
+
+	var decorations []string
+	if attr.hasStyle(underlineStyles | IdeogramUnderline | IdeogramDoubleUnderline) {
+		decorations = append(decorations, "underline")
+	}
+	if attr.hasStyle(Overline | IdeogramOverline | IdeogramDoubleOverline) {
+		decorations = append(decorations, "overline")
+	}
+	if attr.hasStyle(Strikethrough) {
+		decorations = append(decorations, "line-through")
+	}
+	if len(decorations) > 0 {
+		fmt.Fprintf(raw, "text-decoration-line:%s;", strings.Join(decorations, " "))
+		switch {
+		case attr.hasStyle(DoubleUnderline | IdeogramDoubleUnderline | IdeogramDoubleOverline):
+			raw.WriteString("text-decoration-style:double;")
+		case attr.hasStyle(CurlyUnderline):
+			raw.WriteString("text-decoration-style:wavy;")
+		case attr.hasStyle(DottedUnderline):
+			raw.WriteString("text-decoration-style:dotted;")
+		case attr.hasStyle(DashedUnderline):
+			raw.WriteString("text-decoration-style:dashed;")
+		}
+		// CSS only has one decoration color, so the underline color also applies to overlines and strikethroughs.
+		if attr.underline != nil && attr.hasStyle(underlineStyles) {
+			fmt.Fprintf(raw, "text-decoration-color:%s;", r.colorCSS(attr.underline))
+		}
+	}
+	if attr.hasStyle(IdeogramStress) {
+		raw.WriteString("text-emphasis:filled dot;")
+	}
+
+	switch {
+	case attr.hasStyle(RapidBlink):
+		raw.WriteString("animation:term-blink 0.5s step-end infinite;")
+	case attr.hasStyle(Blink):
+		raw.WriteString("animation:term-blink 1s step-end infinite;")
+	}
+
+	switch {
+	case attr.hasStyle(Superscript):
+		raw.WriteString("vertical-align:super;font-size:smaller;")
+	case attr.hasStyle(Subscript):
+		raw.WriteString("vertical-align:sub;font-size:smaller;")
+	}
+
+	switch {
+	case attr.hasStyle(Fraktur):
+		raw.WriteString("font-family:var(--term-font-fraktur, serif);")
+	case attr.font != 0:
+		fmt.Fprintf(raw, "font-family:var(--term-font-%d, inherit);", attr.font)
+	case attr.hasStyle(ProportionalSpacing):
+		raw.WriteString("font-family:sans-serif;")
+	}
+
+	switch {
+	case attr.hasStyle(Framed):
+		raw.WriteString("outline:1px solid;")
+	case attr.hasStyle(Encircled):
+		raw.WriteString("outline:1px solid;border-radius:0.5em;")
+	}
+}
+
+// writeClasses writes the class attribute for attr, plus a style attribute for any truecolor colors, which don't have
+// classes.
+func (r htmlRenderer) writeClasses(raw *strings.Builder, attr *styleAttributes) {
+	var classes []string
+	var style strings.Builder
+	addColor := func(prefix, property string, c Color) {
+		switch c := c.(type) {
+		case nil:
+		case ANSIColor:
+			classes = append(classes, fmt.Sprintf("%s-%d", prefix, c))
+		default:
+			fmt.Fprintf(&style, "%s:%s;", property, c.HTMLColorCode())
+		}
+	}
+	addFlag := func(flags styleFlags, class ...string) {
+		if attr.hasStyle(flags) {
+			classes = append(classes, class...)
+		}
+	}
+
+	addFlag(Bold, "bold")
+	addFlag(Dim, "dim")
+	addFlag(Italic, "italic")
+	addFlag(Hidden, "hidden")
+
+	fg, bg := attr.fg, attr.bg
+	if attr.hasStyle(Inverted) {
+		fg, bg = bg, fg
+		if fg == nil {
+			classes = append(classes, "fg-bg")
+		}
+		if bg == nil {
+			classes = append(classes, "bg-fg")
+		}
+	}
+	if adjusted, ok := r.contrastFg(attr); ok {
+		fg = adjusted
+	}
+	addColor("fg", "color", fg)
+	addColor("bg", "background-color", bg)
+
+	addFlag(underlineStyles|IdeogramUnderline|IdeogramDoubleUnderline, "ul")
+	addFlag(Overline|IdeogramOverline|IdeogramDoubleOverline, "overline")
+	addFlag(Strikethrough, "strike")
+	addFlag(DoubleUnderline|IdeogramDoubleUnderline, "ul-double")
+	addFlag(CurlyUnderline, "ul-curly")
+	addFlag(DottedUnderline, "ul-dotted")
+	addFlag(DashedUnderline, "ul-dashed")
+	addFlag(IdeogramDoubleOverline, "overline-double")
+	if attr.hasStyle(underlineStyles) {
+		addColor("ulc", "text-decoration-color", attr.underline)
+	}
+	addFlag(IdeogramStress, "stress")
+
+	switch {
+	case attr.hasStyle(RapidBlink):
+		classes = append(classes, "blink-rapid")
+	case attr.hasStyle(Blink):
+		classes = append(classes, "blink")
+	}
+	addFlag(Superscript, "sup")
+	addFlag(Subscript, "sub")
+	switch {
+	case attr.hasStyle(Fraktur):
+		classes = append(classes, "fraktur")
+	case attr.font != 0:
+		classes = append(classes, fmt.Sprintf("font-%d", attr.font))
+	case attr.hasStyle(ProportionalSpacing):
+		classes = append(classes, "proportional")
+	}
+	addFlag(Framed, "framed")
+	addFlag(Encircled, "encircled")
+
+	if len(classes) > 0 {
+		fmt.Fprintf(raw, " class=\"%s\"", strings.Join(classes, " "))
+	}
+	if style.Len() > 0 {
+		fmt.Fprintf(raw, " style=\"%s\"", style.String())
 	}
-	return ret
 }
@@ -0,0 +1,126 @@
+package terminal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A terminal can also be fed recorded output instead of a pty, e.g. an asciicast. Lines are then timestamped with
+// the recorded times, so the output looks the same as it did live.
+
+// ReplayEvent is a chunk of recorded output, and when it was written.
+type ReplayEvent struct {
+	Time time.Time
+	Data []byte
+}
+
+// ReplaySource returns recorded output in order, and io.EOF once there's no more.
+type ReplaySource interface {
+	Next() (ReplayEvent, error)
+}
+
+// NewReplay creates a terminal that reads its output from src. There's no application to reply to queries, and
+// requests for full-screen mode are ignored rather than upgraded.
+func NewReplay(src ReplaySource, opts ...RichTextTerminalOption) *RichTextTerminal {
+	t := &RichTextTerminal{
+		clock: time.Now,
+	}
+	rd := io.TeeReader(&replayReader{src: src, t: t}, &t.raw)
+	t.parser = newParser(rd, t)
+	t.screen = newScreen()
+
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// replayReader returns at most one event per Read. The parser only reads when it has parsed everything it read
+// before, so each event is timestamped exactly, however much data arrived close together.
+type replayReader struct {
+	src     ReplaySource
+	t       *RichTextTerminal
+	pending []byte
+}
+
+func (r *replayReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		ev, err := r.src.Next()
+		if err != nil {
+			return 0, err
+		}
+		r.pending = ev.Data
+		r.t.screen.now = ev.Time.UnixNano()
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// maxAsciicastLine is the longest event line we accept in an asciicast file.
+const maxAsciicastLine = 64 << 20
+
+type asciicastSource struct {
+	scanner *bufio.Scanner
+	start   time.Time
+}
+
+// NewAsciicastSource reads an asciicast v2 recording, as made by asciinema rec. Only output events are replayed.
+// See https://docs.asciinema.org/manual/asciicast/v2/
+func NewAsciicastSource(r io.Reader) (ReplaySource, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxAsciicastLine)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("asciicast is empty")
+	}
+
+	var header struct {
+		Version   int   `json:"version"`
+		Timestamp int64 `json:"timestamp"`
+	}
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return nil, fmt.Errorf("invalid asciicast header: %w", err)
+	}
+	if header.Version != 2 {
+		return nil, fmt.Errorf("unsupported asciicast version %d", header.Version)
+	}
+	start := time.Now()
+	if header.Timestamp != 0 {
+		start = time.Unix(header.Timestamp, 0)
+	}
+	return &asciicastSource{scanner, start}, nil
+}
+
+func (s *asciicastSource) Next() (ReplayEvent, error) {
+	for s.scanner.Scan() {
+		if len(s.scanner.Bytes()) == 0 {
+			continue
+		}
+		// Each event is [time, code, data], where time is in seconds since the start.
+		var event []json.RawMessage
+		var elapsed float64
+		var code, data string
+		if err := json.Unmarshal(s.scanner.Bytes(), &event); err != nil || len(event) != 3 {
+			return ReplayEvent{}, fmt.Errorf("invalid asciicast event: %q", s.scanner.Text())
+		}
+		if json.Unmarshal(event[0], &elapsed) != nil || json.Unmarshal(event[1], &code) != nil ||
+			json.Unmarshal(event[2], &data) != nil {
+			return ReplayEvent{}, fmt.Errorf("invalid asciicast event: %q", s.scanner.Text())
+		}
+		if code != "o" {
+			continue
+		}
+		return ReplayEvent{s.start.Add(time.Duration(elapsed * float64(time.Second))), []byte(data)}, nil
+	}
+	if err := s.scanner.Err(); err != nil {
+		return ReplayEvent{}, err
+	}
+	return ReplayEvent{}, io.EOF
+}
@@ -0,0 +1,60 @@
+package terminal
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplayAsciicast(t *testing.T) {
+	// Input events are skipped, and full-screen mode doesn't stop a replay.
+	cast := `{"version": 2, "width": 80, "height": 24, "timestamp": 1700000000}
+[0.5, "o", "hello\r\n"]
+[1.0, "i", "x"]
+[2.25, "o", "wor"]
+[3.0, "o", "ld\r\n\u001b[?1049h"]
+[4.0, "o", "after"]
+`
+	src, err := NewAsciicastSource(strings.NewReader(cast))
+	if err != nil {
+		t.Fatal(err)
+	}
+	term := NewReplay(src)
+	term.Run(context.Background())
+
+	start := time.Unix(1700000000, 0)
+	want := []struct {
+		html              string
+		created, modified float64
+	}{
+		{"hello", 0.5, 0.5},
+		{"world", 2.25, 3.0},
+		{"after", 4.0, 4.0},
+	}
+	lines := term.ChangesSince(0).Lines
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d", len(lines), len(want))
+	}
+	for i, w := range want {
+		l := lines[i]
+		created := start.Add(time.Duration(w.created * float64(time.Second)))
+		modified := start.Add(time.Duration(w.modified * float64(time.Second)))
+		if l.HTML != w.html || !l.Created.Equal(created) || !l.Modified.Equal(modified) {
+			t.Errorf("line %d = %q (%v, %v), want %q (%v, %v)", i, l.HTML, l.Created, l.Modified, w.html, created,
+				modified)
+		}
+	}
+}
+
+func TestAsciicastSourceErrors(t *testing.T) {
+	for _, cast := range []string{
+		"",
+		`{"version": 1}`,
+		"not json",
+	} {
+		if _, err := NewAsciicastSource(strings.NewReader(cast)); err == nil {
+			t.Errorf("NewAsciicastSource(%q) succeeded, want an error", cast)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package terminal
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
 type styleFlags uint32
@@ -18,8 +19,28 @@ const (
 	DoubleUnderline
 	Superscript
 	Subscript
+	CurlyUnderline
+	DottedUnderline
+	DashedUnderline
+	RapidBlink
+	Overline
+	Fraktur
+	ProportionalSpacing
+	Framed
+	Encircled
+	IdeogramUnderline
+	IdeogramDoubleUnderline
+	IdeogramOverline
+	IdeogramDoubleOverline
+	IdeogramStress
 )
 
+// Underline styles are mutually exclusive.
+const underlineStyles = Underline | DoubleUnderline | CurlyUnderline | DottedUnderline | DashedUnderline
+
+const ideogramStyles = IdeogramUnderline | IdeogramDoubleUnderline | IdeogramOverline | IdeogramDoubleOverline |
+	IdeogramStress
+
 type styleAttributes struct {
 	styleFlags
 	// NOTE: A nil color represents the default value
@@ -27,7 +48,20 @@ type styleAttributes struct {
 	bg        Color
 	underline Color
 
+	font uint8 // 0 is the primary font, 1-9 are the alternative fonts selected by SGR 11-19
+
+	link hyperlink
+
+	image *inlineImage // set on an image's cell. See images.go.
+}
+
+// hyperlink is an OSC 8 hyperlink. See https://gist.github.com/egmontkob/eb114294efbcd5adb1944c9f3cb5feda
+type hyperlink struct {
 	uri string
+	// Cells with the same URI and id belong to the same link, even if they aren't adjacent, e.g. a link that wraps
+	// across lines. Links without an explicit id are numbered instead, so that each OSC 8 sequence is its own link.
+	id  string
+	seq int
 }
 
 func (a *styleAttributes) Equals(a2 *styleAttributes) bool {
@@ -38,6 +72,11 @@ func (a *styleAttributes) Empty() bool {
 	return *a == styleAttributes{}
 }
 
+// Unstyled reports whether a has no attributes other than a hyperlink or image.
+func (a *styleAttributes) Unstyled() bool {
+	return *a == styleAttributes{link: a.link, image: a.image}
+}
+
 func (a *styleAttributes) hasStyle(flags styleFlags) bool {
 	return a.styleFlags&flags != 0
 }
@@ -47,24 +86,126 @@ type node struct {
 	*styleAttributes
 }
 
+// cursorState is the state saved by DECSC and restored by DECRC.
+type cursorState struct {
+	pos              int
+	activeAttributes *styleAttributes
+	charsets         charsets
+}
+
 type screen struct {
-	scrollback [][]node // TODO: could also just be slice of rendered HTML segments, one for each line :)
+	scrollback []line
 
 	activeLine []node
 	pos        int
 
 	activeAttributes *styleAttributes
-
-	sync.Mutex // TODO
+	charsets         charsets
+
+	savedCursor *cursorState
+	linkSeq     int
+
+	// rev is bumped on every change to the screen's content. activeRev is the revision at which the active line last
+	// changed; finalised lines record their own revision.
+	rev       uint64
+	activeRev uint64
+
+	// Timestamps for the active line, in Unix nanoseconds. See line.
+	activeCreated, activeModified int64
+
+	// In-place redraws of the active line. See progress.go.
+	redrawing     bool
+	activeRedraws int
+	activeFrames  []line
+	frameLimit    int
+
+	renderer htmlRenderer
+	// basePalette holds the theme's colors, which OSC 104 etc. reset to. The current palette is renderer.palette.
+	basePalette *palette
+	paletteRev  uint64
+
+	// Command blocks from shell integration. See commands.go.
+	commands         []CommandBlock
+	activeCommand    *CommandBlock
+	commandPositions commandPositions
+	cwd              *workingDir
+	pathLinks        bool // see WithPathLinks
+	shellProperties  map[string]string
+	nonce            string // expected in OSC 633 E, if set
+
+	// State reported with iTerm2's OSC 1337. See iterm2.go.
+	userVars   map[string]string
+	marks      []int
+	remoteHost string
+
+	title         titleState
+	titleRev      uint64
+	titleStack    []string
+	iconNameStack []string
+
+	// The text copied with OSC 52, by selection. See clipboard.go.
+	clipboard       map[byte]string
+	clipboardPolicy ClipboardPolicy
+
+	// OSC 99 notifications that are still being sent, by ID. See notify.go.
+	pendingNotifications map[string]*Notification
+
+	// Recent events, and those not yet passed to the event hook. See events.go.
+	events        []eventRecord
+	pendingEvents []Event
+	lastBell      int64 // when the last Bell was emitted, in Unix nanoseconds. See ringBell.
+	// hasPendingEvents is set along with pendingEvents, so flushEvents can check it without taking the lock.
+	hasPendingEvents atomic.Bool
+
+	// kittyPlacements are the kitty graphics placements in the active line, by image and placement ID. See kitty.go.
+	kittyPlacements map[kittyPlacementKey]*inlineImage
+
+	// inputRequested is set once InputRequested has been emitted for the active line, until it changes. See input.go.
+	inputRequested bool
+
+	scrollbackLimit int // 0 means unbounded
+	evicted         int // lines dropped from the front of scrollback
+	spillEnabled    bool
+	spillDir        string
+	spill           *spillFile
+	// scrollbackShared is set once a snapshot may share scrollback's backing array, until it's reallocated.
+	scrollbackShared bool
+
+	// mu guards all of the above. The terminal goroutine holds it while handling each parser event, and readers only
+	// hold it long enough to take a Snapshot.
+	mu sync.Mutex
+
+	// now is when the output currently being parsed arrived, in Unix nanoseconds. It's only accessed from the
+	// terminal goroutine.
+	now int64
 }
 
 func newScreen() screen {
+	base := newPalette(nil)
 	return screen{
 		activeAttributes: &styleAttributes{},
+		basePalette:      base,
+		renderer:         htmlRenderer{palette: base},
+	}
+}
+
+// touch records a change to the active line. It must be called before the line is changed.
+func (s *screen) touch() {
+	if s.redrawing {
+		s.recordFrame()
+	}
+	s.rev++
+	s.activeRev = s.rev
+	s.inputRequested = false
+	if s.activeCreated == 0 {
+		s.activeCreated = s.now
 	}
+	s.activeModified = s.now
 }
 
 func (s *screen) print(r rune) {
+	s.touch()
+	r = s.charsets.translate(r)
 	if s.pos < len(s.activeLine) {
 		s.activeLine[s.pos] = node{r, s.activeAttributes}
 	} else {
@@ -79,13 +220,28 @@ func (s *screen) backspace() {
 		return
 	}
 
+	s.touch()
 	s.activeLine = append(s.activeLine[:s.pos-1], s.activeLine[s.pos:]...)
 	s.pos--
 }
 
 func (s *screen) newline() {
-	s.scrollback = append(s.scrollback, s.activeLine)
+	if s.activeCreated == 0 { // an empty line
+		s.activeCreated, s.activeModified = s.now, s.now
+	}
+	s.rev++
+	s.activeRev = s.rev
+	s.inputRequested = false
+	l := s.compactActiveLine()
+	if s.pathLinks {
+		l.paths = findPathLinks(l.text, s.cwd)
+	}
+	s.scrollback = append(s.scrollback, l)
+	s.evict()
 	s.activeLine = nil
+	s.activeCreated, s.activeModified = 0, 0
+	s.redrawing, s.activeRedraws, s.activeFrames = false, 0, nil
+	s.kittyPlacements = nil // placements can only be deleted from the active line
 	s.pos = 0
 }
 
@@ -98,6 +254,7 @@ func (s *screen) right(n int) {
 }
 
 func (s *screen) cr() {
+	s.markRedraw()
 	s.pos = 0
 }
 
@@ -108,6 +265,9 @@ func (s *screen) newlines(n int) {
 }
 
 func (s *screen) setPos(x, y int) {
+	if y < s.pos {
+		s.markRedraw()
+	}
 	if y < 0 {
 		s.pos = 0
 		return
@@ -118,18 +278,49 @@ func (s *screen) setPos(x, y int) {
 	s.pos = y
 }
 
+// saveCursor implements DECSC. Attributes are never mutated in place, so the pointer can be saved as-is.
+func (s *screen) saveCursor() {
+	s.savedCursor = &cursorState{
+		pos:              s.pos,
+		activeAttributes: s.activeAttributes,
+		charsets:         s.charsets,
+	}
+}
+
+// restoreCursor implements DECRC. Like xterm, restoring without a saved state homes the cursor and resets attributes.
+func (s *screen) restoreCursor() {
+	saved := s.savedCursor
+	if saved == nil {
+		saved = &cursorState{activeAttributes: &styleAttributes{}}
+	}
+	// Restore the attributes first, so that any padding up to the saved column doesn't use the attributes being
+	// replaced.
+	s.activeAttributes = saved.activeAttributes
+	s.charsets = saved.charsets
+	s.setPos(0, saved.pos)
+}
+
+func (s *screen) resetCursorState() {
+	s.savedCursor = nil
+	s.charsets = charsets{}
+}
+
 func (s *screen) clear() {
+	s.markRedraw()
+	s.touch()
 	s.activeLine = []node{}
 	s.pos = 0
 }
 
 func (s *screen) clearLeft() {
+	s.touch()
 	for i := 0; i < s.pos; i++ {
 		s.activeLine[i] = node{' ', s.activeAttributes}
 	}
 }
 
 func (s *screen) clearRight() {
+	s.touch()
 	s.activeLine = s.activeLine[:s.pos]
 }
 
@@ -142,6 +333,11 @@ func (s *screen) resetAttributes() {
 	s.activeAttributes = &styleAttributes{}
 }
 
+// resetGraphicRendition implements SGR 0, which resets all attributes except the hyperlink.
+func (s *screen) resetGraphicRendition() {
+	s.activeAttributes = &styleAttributes{link: s.activeAttributes.link}
+}
+
 func (s *screen) setStyle(flags styleFlags) {
 	s.copyAttributes()
 	s.activeAttributes.styleFlags |= flags
@@ -162,6 +358,26 @@ func (s *screen) setBg(color Color) {
 	s.activeAttributes.bg = color
 }
 
+// setUnderline switches to the given underline style, or turns off underlining if style is 0.
+func (s *screen) setUnderline(style styleFlags) {
+	s.copyAttributes()
+	s.activeAttributes.styleFlags = s.activeAttributes.styleFlags&^underlineStyles | style
+}
+
+func (s *screen) setUnderlineColor(color Color) {
+	s.copyAttributes()
+	s.activeAttributes.underline = color
+}
+
+func (s *screen) resetUnderlineColor() {
+	s.setUnderlineColor(nil)
+}
+
+func (s *screen) setFont(font uint8) {
+	s.copyAttributes()
+	s.activeAttributes.font = font
+}
+
 func (s *screen) resetFg() {
 	s.setFg(nil)
 }
@@ -170,12 +386,17 @@ func (s *screen) resetBg() {
 	s.setBg(nil)
 }
 
-func (s *screen) setURI(uri string) {
+func (s *screen) setLink(uri, id string) {
 	s.copyAttributes()
-	s.activeAttributes.uri = uri
+	link := hyperlink{uri: uri, id: id}
+	if id == "" {
+		s.linkSeq++
+		link.seq = s.linkSeq
+	}
+	s.activeAttributes.link = link
 }
 
-func (s *screen) resetURI() {
+func (s *screen) resetLink() {
 	s.copyAttributes()
-	s.activeAttributes.uri = ""
+	s.activeAttributes.link = hyperlink{}
 }
@@ -0,0 +1,76 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+)
+
+// feed parses input to the end.
+func feed(term *RichTextTerminal, input string) {
+	term.parser = newParser(strings.NewReader(input), term)
+	for term.parser.Continue() == nil {
+	}
+}
+
+func TestSaveRestoreCursor(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+		bold  []bool // whether each cell of the active line is bold, if set
+		plain bool   // whether every cell should be unstyled
+	}{
+		{"DECSC/DECRC", "ab\x1b7cd\x1b8X", "abXd", nil, true},
+		{"SCOSC/SCORC", "ab\x1b[scd\x1b[uX", "abXd", nil, true},
+		{"mode 1048", "ab\x1b[?1048hcd\x1b[?1048lX", "abXd", nil, true},
+		{"restores attributes", "\x1b[1m\x1b7\x1b[0mxy\x1b8z", "zy", []bool{true, false}, false},
+		{"restores charsets", "\x1b(0\x1b7\x1b(Bqq\x1b8q", "─q", nil, true},
+		{"restore without save", "\x1b[1mabc\x1b8x", "xbc", []bool{false, true, true}, false},
+		// The padding up to the saved column must use the restored attributes, not the ones being replaced.
+		{"padding attributes", "abc\x1b7\r\x1b[K\x1b[1m\x1b8x", "   x", nil, true},
+		{"RIS forgets the saved state", "\x1b[1m\x1b(0ab\x1b7\x1bcab\x1b8q", "qb", nil, true},
+		{"DECSTR forgets the saved state", "\x1b[1m\x1b(0ab\x1b7\x1b[!pab\x1b8q", "q␉ab",
+			[]bool{false, true, false, false}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			term := newTestTerminal(nil)
+			feed(term, test.input)
+			l := term.screen.compactActiveLine()
+			if l.text != test.want {
+				t.Errorf("active line = %q, want %q", l.text, test.want)
+			}
+			for i, n := range term.screen.activeLine {
+				if test.plain && !n.styleAttributes.Unstyled() {
+					t.Errorf("cell %d is styled, want it unstyled", i)
+				}
+				if i < len(test.bold) && n.hasStyle(Bold) != test.bold[i] {
+					t.Errorf("cell %d bold = %v, want %v", i, n.hasStyle(Bold), test.bold[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCharsets(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{"ASCII", "q#", "q#"},
+		{"DEC special graphics", "\x1b(0lqk\x1b(Bq", "┌─┐q"},
+		{"United Kingdom", "\x1b(A#a", "£a"},
+		{"shift out and in", "\x1b)0q\x0eq\x0fq", "q─q"},
+		{"locking shift 2", "\x1b*0q\x1bnq", "q─"},
+		{"locking shift 3", "\x1b+Aq\x1bo#", "q£"},
+		{"non-ASCII is unchanged", "\x1b(0é", "é"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			term := newTestTerminal(nil)
+			feed(term, test.input)
+			if got := term.screen.compactActiveLine().text; got != test.want {
+				t.Errorf("active line = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package terminal
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+)
+
+// Lines evicted from a bounded scrollback are rendered and optionally spilled to a temp file so clients can still page
+// through the full history. The file is a sequence of independently-compressed chunks, so reading an old line only
+// needs to decompress the chunk that contains it.
+const spillChunkLines = 256
+
+var errLinesDiscarded = errors.New("requested lines were evicted from the scrollback and not spilled")
+
+type spillChunk struct {
+	offset, size int64
+	lines        int
+}
+
+// spillFile is appended to by the terminal goroutine and read by snapshots on other goroutines, so its chunks are
+// guarded by mu. nLines is only touched while the screen is locked.
+type spillFile struct {
+	mu      sync.Mutex
+	f       *os.File
+	size    int64
+	chunks  []spillChunk
+	pending []string
+	nLines  int
+}
+
+func newSpillFile(dir string) (*spillFile, error) {
+	f, err := os.CreateTemp(dir, "terminal-scrollback-*")
+	if err != nil {
+		return nil, err
+	}
+	return &spillFile{f: f}, nil
+}
+
+func (sf *spillFile) len() int {
+	return sf.nLines
+}
+
+func (sf *spillFile) append(line string) error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	sf.pending = append(sf.pending, line)
+	sf.nLines++
+	if len(sf.pending) >= spillChunkLines {
+		return sf.flush()
+	}
+	return nil
+}
+
+func (sf *spillFile) flush() error {
+	if len(sf.pending) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	zw, err := flate.NewWriter(&buf, flate.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	var lenBuf [binary.MaxVarintLen64]byte
+	for _, line := range sf.pending {
+		n := binary.PutUvarint(lenBuf[:], uint64(len(line)))
+		_, _ = zw.Write(lenBuf[:n])
+		_, _ = io.WriteString(zw, line)
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	if _, err := sf.f.WriteAt(buf.Bytes(), sf.size); err != nil {
+		return err
+	}
+	sf.chunks = append(sf.chunks, spillChunk{offset: sf.size, size: int64(buf.Len()), lines: len(sf.pending)})
+	sf.size += int64(buf.Len())
+	sf.pending = nil
+	return nil
+}
+
+// lines returns the spilled lines in [start, end).
+func (sf *spillFile) lines(start, end int) ([]string, error) {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	ret := make([]string, 0, end-start)
+	first := 0
+	for _, c := range sf.chunks {
+		if first >= end {
+			return ret, nil
+		}
+		if first+c.lines > start {
+			chunk, err := sf.readChunk(c)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, chunk[clamp(start-first, 0, c.lines):clamp(end-first, 0, c.lines)]...)
+		}
+		first += c.lines
+	}
+	if first < end {
+		ret = append(ret, sf.pending[clamp(start-first, 0, len(sf.pending)):end-first]...)
+	}
+	return ret, nil
+}
+
+func (sf *spillFile) readChunk(c spillChunk) ([]string, error) {
+	zr := flate.NewReader(io.NewSectionReader(sf.f, c.offset, c.size))
+	defer zr.Close()
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, fmt.Errorf("reading spilled scrollback: %w", err)
+	}
+
+	ret := make([]string, 0, c.lines)
+	for len(data) > 0 {
+		n, k := binary.Uvarint(data)
+		if k <= 0 || uint64(len(data)-k) < n {
+			return nil, errors.New("spilled scrollback is corrupt")
+		}
+		ret = append(ret, string(data[k:k+int(n)]))
+		data = data[k+int(n):]
+	}
+	return ret, nil
+}
+
+func (sf *spillFile) close() error {
+	sf.mu.Lock()
+	defer sf.mu.Unlock()
+
+	err := sf.f.Close()
+	if rmErr := os.Remove(sf.f.Name()); err == nil {
+		err = rmErr
+	}
+	return err
+}
+
+// evict drops the oldest lines from the scrollback once it grows beyond the limit.
+func (s *screen) evict() {
+	if s.scrollbackLimit <= 0 || len(s.scrollback) <= s.scrollbackLimit {
+		return
+	}
+
+	n := len(s.scrollback) - s.scrollbackLimit
+	if s.spillEnabled {
+		s.spillLines(s.scrollback[:n])
+	}
+	if s.scrollbackShared {
+		// A snapshot may still be reading the backing array, so evicted lines can't be cleared in place. Move the
+		// rest to a new array instead, which the snapshot doesn't see. This happens at most once per snapshot.
+		s.scrollback = append(make([]line, 0, s.scrollbackLimit+1), s.scrollback[n:]...)
+		s.scrollbackShared = false
+	} else {
+		for i := range s.scrollback[:n] {
+			s.scrollback[i] = line{}
+		}
+		s.scrollback = s.scrollback[n:]
+	}
+	s.evicted += n
+}
+
+func (s *screen) spillLines(lines []line) {
+	if s.spill == nil {
+		var err error
+		s.spill, err = newSpillFile(s.spillDir)
+		if err != nil {
+			log.Printf("could not create scrollback spill file, evicted lines will be discarded: %v", err)
+			s.spillEnabled = false
+			return
+		}
+	}
+	for _, l := range lines {
+		if err := s.spill.append(s.renderer.renderLine(l)); err != nil {
+			// Spilled lines must stay contiguous with the in-memory scrollback, so give up on the whole file.
+			log.Printf("could not spill scrollback, evicted lines will be discarded: %v", err)
+			_ = s.closeSpill()
+			return
+		}
+	}
+}
+
+func (s *screen) closeSpill() error {
+	if s.spill == nil {
+		return nil
+	}
+	err := s.spill.close()
+	s.spill = nil
+	s.spillEnabled = false
+	return err
+}
+
+func clamp(n, lo, hi int) int {
+	if n < lo {
+		return lo
+	}
+	if n > hi {
+		return hi
+	}
+	return n
+}
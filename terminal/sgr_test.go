@@ -0,0 +1,60 @@
+package terminal
+
+import "testing"
+
+func TestSGR(t *testing.T) {
+	tests := []struct {
+		name string
+		sgr  string
+		want styleAttributes
+	}{
+		{"curly underline", "4:3", styleAttributes{styleFlags: CurlyUnderline}},
+		{"underline style replaces the previous one", "4;4:5", styleAttributes{styleFlags: DashedUnderline}},
+		{"no underline", "4:3;4:0", styleAttributes{}},
+		{"empty underline style defaults to none", "4;4:", styleAttributes{}},
+		{"underline color with color space", "58:2::1:2:3", styleAttributes{underline: RGBColor{1, 2, 3}}},
+		{"underline color without color space", "58:2:1:2:3", styleAttributes{underline: RGBColor{1, 2, 3}}},
+		{"truecolor fg", "38:2:10:20:30", styleAttributes{fg: RGBColor{10, 20, 30}}},
+		{"indexed bg", "48:5:17", styleAttributes{bg: ANSIColor(17)}},
+		{"semicolon truecolor fg", "38;2;10;20;30", styleAttributes{fg: RGBColor{10, 20, 30}}},
+		{"mixed run", "38;5;1;4:3", styleAttributes{styleFlags: CurlyUnderline, fg: ANSIColor(1)}},
+		{"mixed run, subparameters first", "4:3;1;48;2;1;2;3",
+			styleAttributes{styleFlags: CurlyUnderline | Bold, bg: RGBColor{1, 2, 3}}},
+		{"incomplete color", "38:2:1", styleAttributes{}},
+		{"SGR 21 is double underline", "21", styleAttributes{styleFlags: DoubleUnderline}},
+		{"SGR 22 clears bold and dim", "1;2;3;22", styleAttributes{styleFlags: Italic}},
+		{"subparameters on other attributes are ignored", "1:5", styleAttributes{styleFlags: Bold}},
+		{"reset", "1;31;4:3;0", styleAttributes{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			term := newTestTerminal(nil)
+			feed(term, "\x1b["+test.sgr+"mx")
+			if got := *term.screen.activeLine[0].styleAttributes; got != test.want {
+				t.Errorf("attributes after SGR %s = %+v, want %+v", test.sgr, got, test.want)
+			}
+		})
+	}
+}
+
+// TestCSISubparams checks that subparameters in sequences other than SGR are ignored, rather than breaking the
+// sequence.
+func TestCSISubparams(t *testing.T) {
+	tests := []struct {
+		name, input, want string
+	}{
+		{"CUP", "abc\x1b[1:2Hx", "xbc"},
+		{"CHA", "abc\x1b[2:7Gx", "axc"},
+		{"CUB", "abc\x1b[2:1Dx", "axc"},
+		{"empty param", "abc\x1b[:3Gx", "xbc"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			term := newTestTerminal(nil)
+			feed(term, test.input)
+			if got := term.screen.compactActiveLine().text; got != test.want {
+				t.Errorf("active line = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
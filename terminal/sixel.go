@@ -0,0 +1,213 @@
+package terminal
+
+import (
+	"image"
+	"image/color"
+	"math"
+)
+
+// Sixel images are sent as DCS P1;P2;P3 q <data> ST, where the data draws six rows of pixels at a time. We decode them
+// as the data arrives, and place the finished image at the cursor like an inline image.
+// See https://vt100.net/docs/vt3xx-gp/chapter14.html
+//
+// Like most modern terminals, we ignore the pixel aspect ratio (P1 and the raster attributes) and use square pixels,
+// and pixels take the color their register had when they were drawn. Pixels that aren't drawn are transparent, so the
+// page's background shows through.
+
+// Images larger than this are cropped, so a broken or malicious stream can't use unbounded memory.
+const (
+	maxSixelWidth  = 4096
+	maxSixelHeight = 4096
+)
+
+const sixelRegisters = 1024
+
+type sixelDecoder struct {
+	palette [sixelRegisters]color.NRGBA
+	color   int // the selected register
+
+	// Pixels are kept row by row and only allocated once drawn to, since images grow as they're drawn and the raster
+	// attributes are only a hint.
+	rows          [][]color.NRGBA
+	width, height int
+	x, y          int // the position of the next sixel; y is the top row of the band
+
+	// The command being parsed and its numeric parameters.
+	command byte
+	params  []int
+	param   int
+	inParam bool
+}
+
+// vt340Palette is the VT340's default palette, which programs that don't define their colors rely on.
+var vt340Palette = [16]color.NRGBA{
+	{0, 0, 0, 255}, {51, 51, 204, 255}, {204, 36, 36, 255}, {51, 204, 51, 255},
+	{204, 51, 204, 255}, {51, 204, 204, 255}, {204, 204, 51, 255}, {120, 120, 120, 255},
+	{69, 69, 69, 255}, {87, 87, 153, 255}, {153, 69, 69, 255}, {87, 153, 87, 255},
+	{153, 87, 153, 255}, {87, 153, 153, 255}, {153, 153, 87, 255}, {204, 204, 204, 255},
+}
+
+func newSixelDecoder() *sixelDecoder {
+	d := &sixelDecoder{}
+	for i := range d.palette {
+		d.palette[i] = vt340Palette[i%len(vt340Palette)]
+	}
+	return d
+}
+
+func (d *sixelDecoder) write(data []byte) {
+	for _, c := range data {
+		switch {
+		case c >= '0' && c <= '9':
+			d.param = d.param*10 + int(c-'0')
+			if d.param > math.MaxUint16 {
+				d.param = math.MaxUint16
+			}
+			d.inParam = true
+			continue
+		case c == ';':
+			d.params = append(d.params, d.param)
+			d.param, d.inParam = 0, false
+			continue
+		}
+
+		// Anything else ends the previous command's params.
+		if d.inParam || len(d.params) > 0 {
+			d.params = append(d.params, d.param)
+		}
+		d.endCommand()
+
+		switch {
+		case c >= '?' && c <= '~': // a sixel
+			n := 1
+			if d.command == '!' && len(d.params) > 0 && d.params[0] > 0 { // Graphics Repeat Introducer
+				n = d.params[0]
+			}
+			d.draw(c-'?', n)
+			d.command = 0
+		case c == '$': // Graphics Carriage Return
+			d.x = 0
+			d.command = 0
+		case c == '-': // Graphics New Line
+			d.x = 0
+			d.y += 6
+			d.command = 0
+		case c == '!', c == '#', c == '"':
+			d.command = c
+		default:
+			// Whitespace, newlines and unknown bytes are ignored.
+			d.command = 0
+		}
+		d.params, d.param, d.inParam = d.params[:0], 0, false
+	}
+}
+
+// endCommand applies the params of a color or raster attributes command, once they're complete.
+func (d *sixelDecoder) endCommand() {
+	switch d.command {
+	case '#': // Color Introducer: #Pc selects a register, #Pc;Pu;Px;Py;Pz also defines it
+		if len(d.params) == 0 {
+			break
+		}
+		d.color = clamp(d.params[0], 0, sixelRegisters-1)
+		if len(d.params) >= 5 {
+			d.palette[d.color] = sixelColor(d.params[1], d.params[2], d.params[3], d.params[4])
+		}
+	case '"': // Raster Attributes: "Pan;Pad;Ph;Pv
+		if len(d.params) >= 4 {
+			d.width = clamp(d.params[2], d.width, maxSixelWidth)
+			d.height = clamp(d.params[3], d.height, maxSixelHeight)
+		}
+	}
+	if d.command != '!' { // the repeat count is used by the next sixel
+		d.command = 0
+	}
+}
+
+// sixelColor converts a color definition. In HLS, hue 0 is blue rather than red.
+func sixelColor(space, x, y, z int) color.NRGBA {
+	if space == 1 {
+		r, g, b := hlsToRGB(float64((x+240)%360), float64(clamp(y, 0, 100))/100, float64(clamp(z, 0, 100))/100)
+		return color.NRGBA{r, g, b, 255}
+	}
+	scale := func(n int) uint8 {
+		return uint8((clamp(n, 0, 100)*255 + 50) / 100)
+	}
+	return color.NRGBA{scale(x), scale(y), scale(z), 255}
+}
+
+func hlsToRGB(h, l, s float64) (r, g, b uint8) {
+	c := (1 - math.Abs(2*l-1)) * s
+	hp := h / 60
+	x := c * (1 - math.Abs(math.Mod(hp, 2)-1))
+	var rf, gf, bf float64
+	switch {
+	case hp < 1:
+		rf, gf = c, x
+	case hp < 2:
+		rf, gf = x, c
+	case hp < 3:
+		gf, bf = c, x
+	case hp < 4:
+		gf, bf = x, c
+	case hp < 5:
+		rf, bf = x, c
+	default:
+		rf, bf = c, x
+	}
+	m := l - c/2
+	scale := func(v float64) uint8 {
+		return uint8(math.Round(clampFloat(v+m, 0, 1) * 255))
+	}
+	return scale(rf), scale(gf), scale(bf)
+}
+
+func clampFloat(v, lo, hi float64) float64 {
+	return math.Max(lo, math.Min(hi, v))
+}
+
+// draw draws n copies of a sixel, whose bits are the pixels from top to bottom.
+func (d *sixelDecoder) draw(bits byte, n int) {
+	n = clamp(n, 0, maxSixelWidth-d.x)
+	if bits != 0 {
+		c := d.palette[d.color]
+		for i := 0; i < 6; i++ {
+			y := d.y + i
+			if bits&(1<<i) == 0 || y >= maxSixelHeight {
+				continue
+			}
+			for len(d.rows) <= y {
+				d.rows = append(d.rows, nil)
+			}
+			row := d.rows[y]
+			if len(row) < d.x+n {
+				row = append(row, make([]color.NRGBA, d.x+n-len(row))...)
+				d.rows[y] = row
+			}
+			for x := d.x; x < d.x+n; x++ {
+				row[x] = c
+			}
+			if y+1 > d.height {
+				d.height = y + 1
+			}
+		}
+	}
+	d.x += n
+	if d.x > d.width {
+		d.width = d.x
+	}
+}
+
+// image returns the decoded image, or nil if nothing was drawn.
+func (d *sixelDecoder) image() image.Image {
+	if d.width == 0 || d.height == 0 {
+		return nil
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, d.width, d.height))
+	for y, row := range d.rows {
+		for x, c := range row {
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
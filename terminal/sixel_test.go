@@ -0,0 +1,56 @@
+package terminal
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestSixelDecoder(t *testing.T) {
+	red, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	tests := []struct {
+		name          string
+		data          string
+		width, height int
+		pixels        map[image.Point]color.NRGBA
+	}{
+		{"empty", "", 0, 0, nil},
+		{"one sixel", "#1;2;100;0;0~", 1, 6, map[image.Point]color.NRGBA{{0, 0}: red, {0, 5}: red}},
+		{"partial sixel", "#1;2;100;0;0A", 1, 2, map[image.Point]color.NRGBA{{0, 0}: {}, {0, 1}: red}},
+		{"repeat", "#1;2;100;0;0!3~", 3, 6, map[image.Point]color.NRGBA{{2, 5}: red}},
+		{"new line", "#1;2;100;0;0~-~", 1, 12, map[image.Point]color.NRGBA{{0, 11}: red}},
+		{"carriage return", "#1;2;100;0;0~$#2;2;0;0;100@", 1, 6,
+			map[image.Point]color.NRGBA{{0, 0}: blue, {0, 1}: red}},
+		{"hls", "#1;1;0;50;100~#2;1;120;50;100~", 2, 6, map[image.Point]color.NRGBA{{0, 0}: blue, {1, 0}: red}},
+		{"default palette", "#2~", 1, 6, map[image.Point]color.NRGBA{{0, 0}: vt340Palette[2]}},
+		{"raster attributes", "\"1;1;10;12#1;2;100;0;0~", 10, 12,
+			map[image.Point]color.NRGBA{{0, 0}: red, {9, 11}: {}}},
+		{"cropped", "#1;2;100;0;0!5000~", maxSixelWidth, 6, map[image.Point]color.NRGBA{{maxSixelWidth - 1, 0}: red}},
+		{"ignores whitespace", "#1;2;100;0;0\r\n~ ~", 2, 6, map[image.Point]color.NRGBA{{1, 0}: red}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := newSixelDecoder()
+			// Split the data to check that commands can span writes.
+			half := len(test.data) / 2
+			d.write([]byte(test.data[:half]))
+			d.write([]byte(test.data[half:]))
+
+			img := d.image()
+			if img == nil {
+				if test.width != 0 || test.height != 0 {
+					t.Fatalf("image() = nil, want %dx%d", test.width, test.height)
+				}
+				return
+			}
+			if size := img.Bounds().Size(); size.X != test.width || size.Y != test.height {
+				t.Fatalf("image size = %dx%d, want %dx%d", size.X, size.Y, test.width, test.height)
+			}
+			for p, want := range test.pixels {
+				if got := color.NRGBAModel.Convert(img.At(p.X, p.Y)); got != want {
+					t.Errorf("pixel %v = %v, want %v", p, got, want)
+				}
+			}
+		})
+	}
+}
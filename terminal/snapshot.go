@@ -0,0 +1,149 @@
+package terminal
+
+// Snapshot is a consistent, immutable view of the screen. The terminal goroutine keeps running while a snapshot is
+// rendered, so readers such as HTTP handlers should take one snapshot per request and read everything from it.
+//
+// Taking a snapshot is cheap: finalised scrollback lines are never modified, so the snapshot shares them with the
+// screen and only the active line has to be copied.
+type Snapshot struct {
+	scrollback []line
+	active     line
+	evicted    int
+
+	rev       uint64
+	activeRev uint64
+
+	renderer    htmlRenderer
+	basePalette *palette
+	paletteRev  uint64
+
+	spill   *spillFile
+	spilled int
+
+	commands        []CommandBlock
+	activeCommand   *CommandBlock
+	shellProperties map[string]string
+
+	userVars map[string]string
+	marks    []int
+
+	cwd      *workingDir
+	title    titleState
+	titleRev uint64
+	events   []eventRecord
+}
+
+// Snapshot captures the current state of the screen. It's safe to call from any goroutine.
+func (s *screen) Snapshot() Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := Snapshot{
+		scrollback:  s.scrollback[:len(s.scrollback):len(s.scrollback)],
+		active:      s.compactActiveLine(),
+		evicted:     s.evicted,
+		rev:         s.rev,
+		activeRev:   s.activeRev,
+		renderer:    s.renderer,
+		basePalette: s.basePalette,
+		paletteRev:  s.paletteRev,
+		spill:       s.spill,
+	}
+	s.scrollbackShared = true
+	if s.spill != nil {
+		snap.spilled = s.spill.len()
+	}
+	if s.activeCommand != nil {
+		activeCommand := *s.activeCommand
+		snap.activeCommand = &activeCommand
+	}
+	snap.commands = s.commands[:len(s.commands):len(s.commands)]
+	snap.shellProperties = s.shellProperties
+	snap.userVars = s.userVars
+	snap.marks = s.marks[:len(s.marks):len(s.marks)]
+	snap.cwd = s.cwd
+	snap.title, snap.titleRev = s.title, s.titleRev
+	snap.events = s.events[:len(s.events):len(s.events)]
+	return snap
+}
+
+func (s *screen) compactActiveLine() line {
+	l := compactLine(s.activeLine)
+	l.rev, l.created, l.modified = s.activeRev, s.activeCreated, s.activeModified
+	l.palette = s.renderer.palette
+	l.redraws, l.frames = s.activeRedraws, s.activeFrames[:len(s.activeFrames):len(s.activeFrames)]
+	return l
+}
+
+// line returns the line with the given ID, if it's still in memory.
+func (sn Snapshot) line(id int) (line, bool) {
+	i := id - sn.evicted
+	switch {
+	case i < 0 || i > len(sn.scrollback):
+		return line{}, false
+	case i == len(sn.scrollback):
+		return sn.active, true
+	default:
+		return sn.scrollback[i], true
+	}
+}
+
+// Lines renders the lines that are still held in memory, followed by the active line.
+func (sn Snapshot) Lines() []string {
+	ret := make([]string, 0, len(sn.scrollback)+1)
+	for _, l := range sn.scrollback {
+		ret = append(ret, sn.renderer.renderLine(l))
+	}
+	return append(ret, sn.renderer.renderLine(sn.active))
+}
+
+// HistoryLen returns the number of lines ever written, including evicted lines and the active line.
+func (sn Snapshot) HistoryLen() int {
+	return sn.evicted + len(sn.scrollback) + 1
+}
+
+// History renders the lines in [start, end), where line 0 is the first line ever written. Lines that were evicted
+// are read back from the spill file, if there is one.
+func (sn Snapshot) History(start, end int) ([]string, error) {
+	start, end = clamp(start, 0, sn.HistoryLen()), clamp(end, 0, sn.HistoryLen())
+	if start >= end {
+		return nil, nil
+	}
+
+	var ret []string
+	if start < sn.evicted {
+		discarded := sn.evicted - sn.spilled
+		if start < discarded {
+			return nil, errLinesDiscarded
+		}
+		lines, err := sn.spill.lines(start-discarded, clamp(end, 0, sn.evicted)-discarded)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, lines...)
+		start = sn.evicted
+	}
+	for i := start; i < end; i++ {
+		if i-sn.evicted < len(sn.scrollback) {
+			ret = append(ret, sn.renderer.renderLine(sn.scrollback[i-sn.evicted]))
+		} else {
+			ret = append(ret, sn.renderer.renderLine(sn.active))
+		}
+	}
+	return ret, nil
+}
+
+// Lines is shorthand for Snapshot().Lines().
+func (s *screen) Lines() []string {
+	return s.Snapshot().Lines()
+}
+
+// HistoryLen is shorthand for Snapshot().HistoryLen().
+func (s *screen) HistoryLen() int {
+	return s.Snapshot().HistoryLen()
+}
+
+// History is shorthand for Snapshot().History(start, end).
+func (s *screen) History(start, end int) ([]string, error) {
+	return s.Snapshot().History(start, end)
+}
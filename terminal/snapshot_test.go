@@ -0,0 +1,99 @@
+package terminal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestTerminal returns a terminal that reads from r, without a pty.
+func newTestTerminal(r io.Reader, opts ...RichTextTerminalOption) *RichTextTerminal {
+	t := &RichTextTerminal{screen: newScreen()}
+	t.parser = newParser(r, t)
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t
+}
+
+// runWithReplies feeds input to a terminal and returns what it replied to the application.
+func runWithReplies(t *testing.T, input string, opts ...RichTextTerminalOption) (string, *RichTextTerminal) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	term := newTestTerminal(strings.NewReader(input), opts...)
+	term.src = w
+	for term.parser.Continue() == nil {
+	}
+	w.Close()
+	replies, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(replies), term
+}
+
+// TestSnapshotConcurrentReaders is meant to be run with -race. Readers take snapshots while the terminal goroutine
+// appends, redraws and evicts lines.
+func TestSnapshotConcurrentReaders(t *testing.T) {
+	r, w := io.Pipe()
+	term := newTestTerminal(r, WithScrollbackLimit(10), WithScrollbackSpill(t.TempDir()), WithProgressFrames(4))
+	defer term.Close()
+
+	go func() {
+		for i := 0; i < 500; i++ {
+			fmt.Fprintf(w, "\x1b[3%dmline %d\x1b[m\r\x1b[1mline %d\x1b[m\n", i%8, i, i)
+		}
+		w.Close()
+	}()
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var rev uint64
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				snap := term.Snapshot()
+				snap.Lines()
+				rev = snap.ChangesSince(rev).Rev
+				// Include some spilled lines, without decompressing the whole spill file every time.
+				if _, err := snap.History(snap.HistoryLen()-30, snap.HistoryLen()); err != nil {
+					t.Errorf("History: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	for term.parser.Continue() == nil {
+	}
+	close(done)
+	wg.Wait()
+
+	snap := term.Snapshot()
+	if got, want := snap.HistoryLen(), 501; got != want {
+		t.Fatalf("HistoryLen() = %d, want %d", got, want)
+	}
+	history, err := snap.History(0, 500)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, l := range history {
+		if want := fmt.Sprintf("line %d", i); !strings.Contains(l, want) {
+			t.Fatalf("History line %d = %q, want it to contain %q", i, l, want)
+		}
+	}
+}
@@ -4,10 +4,13 @@ import (
 	"bytes"
 	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/creack/pty"
 )
@@ -16,31 +19,47 @@ import (
 // comes at the expense of support for interactive terminal applications.
 //
 // It behaves like a terminal with a single, 4k-char-wide line of output and an infinitely-deep scrollback buffer.
+// The scrollback can be bounded with WithScrollbackLimit, optionally spilling old lines to disk.
 //   - Any attempt to move the cursor to an absolute position will silently fail.
 //   - TODO: Any attempt to move the cursor to a different relative line will instead copy that line to a new line.
 //
 // It also has some unique features:
 //   - If it observes that an application is requesting full-screen mode, it will stop running and instead upgrade to a
 //     full-featured terminal.
-//   - TODO: It tries to detect a prompt and notify the client that it's waiting for input.
+//   - If the shell reports prompts with OSC 133, it splits the output into command blocks (see Commands).
+//   - Otherwise, it can guess when the application is waiting for input (see WithInputDetection).
 type RichTextTerminal struct {
 	*parser
 	screen
 
 	src *os.File
 	raw bytes.Buffer
+	// replies are queued while the screen is locked. Like the parser, they're only accessed by the terminal goroutine.
+	replies bytes.Buffer
 
 	upgraded    bool
 	upgradeHook func(*os.File)
+
+	clock func() time.Time
+
+	sixel *sixelDecoder // the sixel image being received, if any
+	kitty kittyState
+
+	eventHook   func(Event)
+	eventHookMu sync.Mutex // so the hook isn't called concurrently by Run and the input detector
+
+	inputIdle  time.Duration
+	inputTimer *time.Timer
 }
 
 func New(src *os.File, opts ...RichTextTerminalOption) *RichTextTerminal {
 	// TODO: Might be a good idea to check here if src is a tty
 
 	t := &RichTextTerminal{
-		src: src,
+		src:   src,
+		clock: time.Now,
 	}
-	rd := io.TeeReader(src, &t.raw)
+	rd := io.TeeReader(&arrivalReader{src, t}, &t.raw)
 	t.parser = newParser(rd, t)
 	t.screen = newScreen()
 
@@ -63,9 +82,12 @@ func New(src *os.File, opts ...RichTextTerminalOption) *RichTextTerminal {
 func (t *RichTextTerminal) Run(ctx context.Context) {
 	// There are effectively two nested state machines: the parser, which reads bytes from the pty and calls event
 	// handlers on escape sequences, and the terminal, which advances the parser and updates the screen on those calls.
+	defer t.stopInputDetection()
+
 	var err error
 	for err == nil {
-		if t.upgraded {
+		if t.upgraded && t.src != nil { // there's nothing to upgrade when replaying
+			t.upgrade()
 			return
 		}
 
@@ -76,6 +98,7 @@ func (t *RichTextTerminal) Run(ctx context.Context) {
 		}
 
 		err = t.parser.Continue()
+		t.flushEvents()
 	}
 
 	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, syscall.EIO) {
@@ -83,6 +106,58 @@ func (t *RichTextTerminal) Run(ctx context.Context) {
 	}
 }
 
+// Close releases any resources held by the terminal, such as the scrollback spill file. It doesn't close src.
+func (t *RichTextTerminal) Close() error {
+	t.screen.mu.Lock()
+	defer t.screen.mu.Unlock()
+
+	return t.screen.closeSpill()
+}
+
+// arrivalReader records when each chunk of output arrived, so that lines can be timestamped.
+type arrivalReader struct {
+	io.Reader
+	t *RichTextTerminal
+}
+
+func (r *arrivalReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.t.screen.now = r.t.clock().UnixNano()
+		r.t.outputArrived()
+	}
+	return n, err
+}
+
+// reply writes a response to a query back to the application. It must not be called with the screen locked, since
+// the write blocks if the application isn't reading; use queueReply instead.
+func (t *RichTextTerminal) reply(format string, a ...any) {
+	if t.src == nil {
+		return
+	}
+	if _, err := fmt.Fprintf(t.src, format, a...); err != nil {
+		log.Printf("could not reply to query: %v", err)
+	}
+}
+
+// queueReply queues a response to a query, to be written by flushReplies once the screen is unlocked.
+func (t *RichTextTerminal) queueReply(format string, a ...any) {
+	if t.src == nil {
+		return
+	}
+	fmt.Fprintf(&t.replies, format, a...)
+}
+
+func (t *RichTextTerminal) flushReplies() {
+	if t.replies.Len() == 0 {
+		return
+	}
+	if _, err := t.src.Write(t.replies.Bytes()); err != nil {
+		log.Printf("could not reply to query: %v", err)
+	}
+	t.replies.Reset()
+}
+
 type RichTextTerminalOption func(*RichTextTerminal)
 
 func WithUpgradeHook(hook func(*os.File)) RichTextTerminalOption {
@@ -90,3 +165,108 @@ func WithUpgradeHook(hook func(*os.File)) RichTextTerminalOption {
 		t.upgradeHook = hook
 	}
 }
+
+// WithScrollbackLimit keeps at most lines lines of scrollback in memory, evicting the oldest first.
+func WithScrollbackLimit(lines int) RichTextTerminalOption {
+	return func(t *RichTextTerminal) {
+		t.screen.scrollbackLimit = lines
+	}
+}
+
+// WithScrollbackSpill compresses lines evicted from the scrollback into a temp file in dir (or the default temp
+// directory if dir is ""), so they can still be read with History.
+func WithScrollbackSpill(dir string) RichTextTerminalOption {
+	return func(t *RichTextTerminal) {
+		t.screen.spillEnabled = true
+		t.screen.spillDir = dir
+	}
+}
+
+// WithClock sets the clock used to timestamp lines as output arrives from the pty. Replayed output is timestamped
+// with its recorded times instead (see NewReplay).
+func WithClock(clock func() time.Time) RichTextTerminalOption {
+	return func(t *RichTextTerminal) {
+		t.clock = clock
+	}
+}
+
+// WithProgressFrames keeps up to n intermediate frames of each line that's redrawn in place, such as a progress bar,
+// so they can be played back with Snapshot.Frames.
+func WithProgressFrames(n int) RichTextTerminalOption {
+	return func(t *RichTextTerminal) {
+		t.screen.frameLimit = n
+	}
+}
+
+// WithCSSClasses renders styles and palette colors as CSS classes instead of inline styles, so the page can apply a
+// Theme with Theme.WriteStylesheet.
+func WithCSSClasses() RichTextTerminalOption {
+	return func(t *RichTextTerminal) {
+		t.screen.renderer.classes = true
+	}
+}
+
+// WithLinkPolicy sets which OSC 8 hyperlinks are rendered as links. See LinkPolicy for the default.
+func WithLinkPolicy(policy LinkPolicy) RichTextTerminalOption {
+	return func(t *RichTextTerminal) {
+		t.screen.renderer.links = policy
+	}
+}
+
+// WithTheme sets the terminal's default colors, which applications can query and change with OSC 4, 10, 11 and 12.
+// It should match the theme of the page the output is shown on.
+func WithTheme(theme *Theme) RichTextTerminalOption {
+	return func(t *RichTextTerminal) {
+		t.screen.basePalette = newPalette(theme)
+		t.screen.renderer.palette = t.screen.basePalette
+	}
+}
+
+// WithMinimumContrast lightens or darkens the foreground of styled text whose foreground and background colors have a
+// WCAG contrast ratio below ratio, e.g. 4.5. Default colors are taken from the theme (see WithTheme).
+func WithMinimumContrast(ratio float64) RichTextTerminalOption {
+	return func(t *RichTextTerminal) {
+		t.screen.renderer.minContrast = ratio
+	}
+}
+
+// WithShellIntegrationNonce only accepts command lines reported with OSC 633 E if they include this nonce. It should
+// be passed to the shell's integration script in the VSCODE_NONCE environment variable.
+func WithShellIntegrationNonce(nonce string) RichTextTerminalOption {
+	return func(t *RichTextTerminal) {
+		t.screen.nonce = nonce
+	}
+}
+
+// WithEventHook calls hook with each Event as it happens, e.g. InputRequested. The hook is called on the terminal's
+// goroutine (or the input detector's), without the screen locked, so it can take snapshots but shouldn't block.
+func WithEventHook(hook func(Event)) RichTextTerminalOption {
+	return func(t *RichTextTerminal) {
+		t.eventHook = hook
+	}
+}
+
+// WithInputDetection emits InputRequested when the application hasn't written anything for idle, and seems to be
+// waiting at a prompt. src must be the pty master, so its line discipline can be inspected.
+func WithInputDetection(idle time.Duration) RichTextTerminalOption {
+	return func(t *RichTextTerminal) {
+		t.inputIdle = idle
+	}
+}
+
+// WithPathLinks links relative and absolute paths in the output to the files they refer to, if they exist. Relative
+// paths are resolved against the working directory the shell reports with OSC 7 (or OSC 633 P;Cwd), as of when the
+// line is finished. The active line isn't linked until then. Links are subject to the LinkPolicy.
+func WithPathLinks() RichTextTerminalOption {
+	return func(t *RichTextTerminal) {
+		t.screen.pathLinks = true
+	}
+}
+
+// WithClipboardPolicy sets what applications can do with the clipboard through OSC 52. By default, they can copy to it
+// but not read it back.
+func WithClipboardPolicy(policy ClipboardPolicy) RichTextTerminalOption {
+	return func(t *RichTextTerminal) {
+		t.screen.clipboardPolicy = policy
+	}
+}
@@ -0,0 +1,18 @@
+//go:build darwin || dragonfly || freebsd || netbsd || openbsd
+
+package terminal
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// ptyLineMode reports whether the pty is in canonical mode and whether echo is on.
+func ptyLineMode(pty *os.File) (canonical, echo bool, err error) {
+	termios, err := unix.IoctlGetTermios(int(pty.Fd()), unix.TIOCGETA)
+	if err != nil {
+		return false, false, err
+	}
+	return termios.Lflag&unix.ICANON != 0, termios.Lflag&unix.ECHO != 0, nil
+}
@@ -0,0 +1,17 @@
+package terminal
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// ptyLineMode reports whether the pty is in canonical mode and whether echo is on. On Linux, the termios of a pty
+// master is that of its slave, which is what the application sets.
+func ptyLineMode(pty *os.File) (canonical, echo bool, err error) {
+	termios, err := unix.IoctlGetTermios(int(pty.Fd()), unix.TCGETS)
+	if err != nil {
+		return false, false, err
+	}
+	return termios.Lflag&unix.ICANON != 0, termios.Lflag&unix.ECHO != 0, nil
+}
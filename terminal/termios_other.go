@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+
+package terminal
+
+import (
+	"errors"
+	"os"
+)
+
+func ptyLineMode(pty *os.File) (canonical, echo bool, err error) {
+	return false, false, errors.New("reading the pty mode is not supported on this platform")
+}
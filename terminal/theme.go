@@ -0,0 +1,123 @@
+package terminal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Theme sets the default colors and the 16 ANSI colors used by the class-based renderer (see WithCSSClasses).
+// Themes can be loaded from JSON files, for example:
+//
+//	{
+//	  "name": "Dracula",
+//	  "foreground": "#f8f8f2",
+//	  "background": "#282a36",
+//	  "palette": ["#21222c", "#ff5555", ...]
+//	}
+type Theme struct {
+	Name       string `json:"name"`
+	Foreground string `json:"foreground"`
+	Background string `json:"background"`
+	Cursor     string `json:"cursor,omitempty"`
+	// Palette overrides the first len(Palette) colors of the 256-color palette. Usually it has 16 entries.
+	Palette []string `json:"palette"`
+}
+
+// XtermTheme is xterm's default color scheme.
+var XtermTheme = Theme{
+	Name:       "xterm",
+	Foreground: "#000000",
+	Background: "#ffffff",
+	Palette: []string{
+		"#000000", "#cd0000", "#00cd00", "#cdcd00", "#0000ee", "#cd00cd", "#00cdcd", "#e5e5e5",
+		"#7f7f7f", "#ff0000", "#00ff00", "#ffff00", "#5c5cff", "#ff00ff", "#00ffff", "#ffffff",
+	},
+}
+
+func LoadTheme(path string) (*Theme, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	th := &Theme{}
+	if err := json.Unmarshal(data, th); err != nil {
+		return nil, fmt.Errorf("parsing theme %s: %w", path, err)
+	}
+	if len(th.Palette) > len(ansiColorPalette) {
+		return nil, fmt.Errorf("theme %s has more than %d palette colors", path, len(ansiColorPalette))
+	}
+	return th, nil
+}
+
+// Color returns the CSS color for c in this theme.
+func (th *Theme) Color(c ANSIColor) string {
+	if int(c) < len(th.Palette) {
+		return th.Palette[c]
+	}
+	return c.HTMLColorCode()
+}
+
+// styleClasses are the CSS classes for style flags, emitted by the class-based renderer.
+var styleClasses = []struct {
+	class string
+	css   string
+}{
+	{"bold", "font-weight:bold"},
+	{"dim", "opacity:0.5"},
+	{"italic", "font-style:italic"},
+	{"hidden", "visibility:hidden"},
+	{"ul", "text-decoration-line:underline"},
+	{"overline", "text-decoration-line:overline"},
+	{"strike", "text-decoration-line:line-through"},
+	{"ul.overline", "text-decoration-line:underline overline"},
+	{"ul.strike", "text-decoration-line:underline line-through"},
+	{"overline.strike", "text-decoration-line:overline line-through"},
+	{"ul.overline.strike", "text-decoration-line:underline overline line-through"},
+	{"ul-double", "text-decoration-style:double"},
+	{"ul-curly", "text-decoration-style:wavy"},
+	{"ul-dotted", "text-decoration-style:dotted"},
+	{"ul-dashed", "text-decoration-style:dashed"},
+	{"overline-double", "text-decoration-style:double"},
+	{"stress", "text-emphasis:filled dot"},
+	{"blink", "animation:term-blink 1s step-end infinite"},
+	{"blink-rapid", "animation:term-blink 0.5s step-end infinite"},
+	{"sup", "vertical-align:super;font-size:smaller"},
+	{"sub", "vertical-align:sub;font-size:smaller"},
+	{"fraktur", "font-family:var(--term-font-fraktur, serif)"},
+	{"proportional", "font-family:sans-serif"},
+	{"framed", "outline:1px solid"},
+	{"encircled", "outline:1px solid;border-radius:0.5em"},
+	// Inverted default colors
+	{"fg-bg", "color:var(--term-bg)"},
+	{"bg-fg", "background-color:var(--term-fg)"},
+}
+
+// WriteStylesheet writes the CSS for the class-based renderer with this theme's colors. It also sets the --term-fg
+// and --term-bg variables used by the inline renderer.
+func (th *Theme) WriteStylesheet(w io.Writer) error {
+	var css strings.Builder
+	fmt.Fprintf(&css, ":root{--term-fg:%s;--term-bg:%s;", th.Foreground, th.Background)
+	if th.Cursor != "" {
+		fmt.Fprintf(&css, "--term-cursor:%s;", th.Cursor)
+	}
+	css.WriteString("}\n")
+	css.WriteString("@keyframes term-blink{50%{opacity:0}}\n")
+
+	for i := range ansiColorPalette {
+		color := th.Color(ANSIColor(i))
+		fmt.Fprintf(&css, ".fg-%d{color:%s}.bg-%d{background-color:%s}.ulc-%d{text-decoration-color:%s}\n",
+			i, color, i, color, i, color)
+	}
+	for i := 1; i <= 9; i++ {
+		fmt.Fprintf(&css, ".font-%d{font-family:var(--term-font-%d, inherit)}\n", i, i)
+	}
+	for _, sc := range styleClasses {
+		fmt.Fprintf(&css, ".%s{%s}\n", sc.class, sc.css)
+	}
+
+	_, err := io.WriteString(w, css.String())
+	return err
+}
@@ -0,0 +1,88 @@
+package terminal
+
+// xterm keeps stacks of window titles and icon names, so that an application can set its own title and restore the
+// previous one when it exits. We keep the same state, and tell clients when it changes.
+
+// maxTitleStack matches xterm's limit, which applies to each stack.
+const maxTitleStack = 10
+
+// TitleChanged is emitted when the application changes the window title or icon name (OSC 0, 1 and 2).
+type TitleChanged struct {
+	Title    string `json:"title"`
+	IconName string `json:"iconName"`
+}
+
+func (TitleChanged) EventType() string { return "titleChanged" }
+
+// Which parts of the title state CSI 22 t and CSI 23 t apply to.
+const (
+	titleAndIconName = 0
+	iconName         = 1
+	windowTitle      = 2
+)
+
+type titleState struct {
+	title, iconName string
+}
+
+func (s *screen) setTitle(title string) {
+	s.updateTitle(titleState{title, s.title.iconName})
+}
+
+func (s *screen) setIconName(name string) {
+	s.updateTitle(titleState{s.title.title, name})
+}
+
+func (s *screen) updateTitle(title titleState) {
+	if title == s.title {
+		return
+	}
+	s.title = title
+	s.emit(TitleChanged{title.title, title.iconName})
+	s.titleRev = s.rev
+}
+
+// pushTitle implements CSI 22 t, which pushes the window title, the icon name or both onto their stacks.
+func (s *screen) pushTitle(which int) {
+	push := func(stack []string, v string) []string {
+		if len(stack) >= maxTitleStack {
+			stack = stack[1:]
+		}
+		return append(stack, v)
+	}
+	if which == titleAndIconName || which == windowTitle {
+		s.titleStack = push(s.titleStack, s.title.title)
+	}
+	if which == titleAndIconName || which == iconName {
+		s.iconNameStack = push(s.iconNameStack, s.title.iconName)
+	}
+}
+
+// popTitle implements CSI 23 t. Each stack is popped independently, so an empty one leaves its part unchanged.
+func (s *screen) popTitle(which int) {
+	pop := func(stack []string, v *string) []string {
+		if len(stack) == 0 {
+			return stack
+		}
+		*v = stack[len(stack)-1]
+		return stack[:len(stack)-1]
+	}
+	title := s.title
+	if which == titleAndIconName || which == windowTitle {
+		s.titleStack = pop(s.titleStack, &title.title)
+	}
+	if which == titleAndIconName || which == iconName {
+		s.iconNameStack = pop(s.iconNameStack, &title.iconName)
+	}
+	s.updateTitle(title)
+}
+
+// Title returns the window title.
+func (sn Snapshot) Title() string {
+	return sn.title.title
+}
+
+// IconName returns the icon name, which some terminals show as the tab title.
+func (sn Snapshot) IconName() string {
+	return sn.title.iconName
+}
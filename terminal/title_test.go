@@ -0,0 +1,59 @@
+package terminal
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTitleStack(t *testing.T) {
+	tests := []struct {
+		name        string
+		data        string
+		title, icon string
+	}{
+		{"set both", "\x1b]0;a\x07", "a", "a"},
+		{"set separately", "\x1b]2;a\x07\x1b]1;b\x07", "a", "b"},
+		{"push and pop both", "\x1b]0;a\x07\x1b[22t\x1b]0;b\x07\x1b[23t", "a", "a"},
+		{"pop title only", "\x1b]0;a\x07\x1b[22t\x1b]0;b\x07\x1b[23;2t", "a", "b"},
+		{"pop icon name only", "\x1b]0;a\x07\x1b[22t\x1b]0;b\x07\x1b[23;1t", "b", "a"},
+		{"push title only", "\x1b]0;a\x07\x1b[22;2t\x1b]0;b\x07\x1b[23t", "a", "b"},
+		{"push icon name only", "\x1b]0;a\x07\x1b[22;1t\x1b]0;b\x07\x1b[23t", "b", "a"},
+		{"separate stacks", "\x1b]0;a\x07\x1b[22;2t\x1b]0;b\x07\x1b[22;1t\x1b]0;c\x07\x1b[23;2t\x1b[23;1t", "a", "b"},
+		{"pop empty stack", "\x1b]0;a\x07\x1b[23t", "a", "a"},
+		{"stack limit", "\x1b]2;a\x07" + strings.Repeat("\x1b[22;2t", 11) + "\x1b]2;b\x07" + strings.Repeat("\x1b[23;2t", 11),
+			"a", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			term := newTestTerminal(strings.NewReader(test.data))
+			for term.parser.Continue() == nil {
+			}
+			snap := term.Snapshot()
+			if snap.Title() != test.title || snap.IconName() != test.icon {
+				t.Errorf("title, icon name = %q, %q, want %q, %q", snap.Title(), snap.IconName(), test.title, test.icon)
+			}
+		})
+	}
+}
+
+func TestChangesTitle(t *testing.T) {
+	r := strings.NewReader("\x1b]0;a\x07hello\n")
+	term := newTestTerminal(r)
+	for term.parser.Continue() == nil {
+	}
+
+	changes := term.Snapshot().ChangesSince(0)
+	if changes.Title == nil || changes.Title.Title != "a" || changes.Title.IconName != "a" {
+		t.Fatalf("ChangesSince(0).Title = %+v, want a", changes.Title)
+	}
+	if title := term.Snapshot().ChangesSince(changes.Rev).Title; title != nil {
+		t.Errorf("Title = %+v without changes, want nil", title)
+	}
+
+	term.parser = newParser(strings.NewReader("\x1b]2;b\x07"), term)
+	for term.parser.Continue() == nil {
+	}
+	if title := term.Snapshot().ChangesSince(changes.Rev).Title; title == nil || title.Title != "b" || title.IconName != "a" {
+		t.Errorf("Title = %+v after a change, want b, a", title)
+	}
+}
@@ -0,0 +1,73 @@
+package terminal
+
+import (
+	"strconv"
+	"strings"
+)
+
+// VS Code's shell integration (OSC 633) extends OSC 133 with the exact command line (E), which doesn't depend on
+// parsing the screen, and with properties of the shell, like its working directory (P).
+// See https://code.visualstudio.com/docs/terminal/shell-integration#_supported-escape-sequences
+
+// unescapeVSCodeValue decodes a value from OSC 633 E or P, where backslashes are escaped as \\ and other characters
+// (including ';' and newlines) may be escaped as \xAB.
+func unescapeVSCodeValue(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+
+	var ret strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c == '\\' && i+1 < len(value) {
+			if value[i+1] == '\\' {
+				ret.WriteByte('\\')
+				i++
+				continue
+			}
+			if value[i+1] == 'x' && i+3 < len(value) {
+				if b, err := strconv.ParseUint(value[i+2:i+4], 16, 8); err == nil {
+					ret.WriteByte(byte(b))
+					i += 3
+					continue
+				}
+			}
+		}
+		ret.WriteByte(c)
+	}
+	return ret.String()
+}
+
+// setCommandLine records the command line reported by the shell, instead of reading it off the screen.
+func (s *screen) setCommandLine(commandLine string) {
+	if s.activeCommand == nil {
+		s.markPromptStart()
+	}
+	s.activeCommand.Command = commandLine
+}
+
+// withEntry returns a copy of m with key set to value. Maps that snapshots share are replaced this way rather than
+// modified.
+func withEntry(m map[string]string, key, value string) map[string]string {
+	ret := make(map[string]string, len(m)+1)
+	for k, v := range m {
+		ret[k] = v
+	}
+	ret[key] = value
+	return ret
+}
+
+// setShellProperty records a property reported by the shell.
+func (s *screen) setShellProperty(key, value string) {
+	s.shellProperties = withEntry(s.shellProperties, key, value)
+
+	if key == "Cwd" {
+		s.setCwd(&workingDir{path: value})
+	}
+}
+
+// ShellProperties returns the properties reported by the shell with OSC 633 P, e.g. "IsWindows". The returned map
+// must not be modified.
+func (sn Snapshot) ShellProperties() map[string]string {
+	return sn.shellProperties
+}
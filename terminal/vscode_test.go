@@ -0,0 +1,25 @@
+package terminal
+
+import "testing"
+
+func TestUnescapeVSCodeValue(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"", ""},
+		{"ls -la", "ls -la"},
+		{`a\\b`, `a\b`},
+		{`echo a\x3bb`, "echo a;b"},
+		{`a\x0Ab`, "a\nb"},
+		{`\\x3b`, `\x3b`},
+		{`a\`, `a\`},
+		{`a\x3`, `a\x3`},
+		{`a\xzz`, `a\xzz`},
+		{`a\nb`, `a\nb`},
+	}
+	for _, test := range tests {
+		if got := unescapeVSCodeValue(test.value); got != test.want {
+			t.Errorf("unescapeVSCodeValue(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}